@@ -0,0 +1,260 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reject requests that do not include the admin token as a bearer token
+func (api ReceiptsApi) RequireAdminToken(c *gin.Context) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(api.Config.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(401, gin.H{
+			"error": "A valid admin token is required.",
+		})
+
+		return
+	}
+
+	c.Next()
+}
+
+// Recalculate the points stored on receipts after the scoring rules have changed. Only receipts scored by a different rules
+// version are recalculated, unless the `all` query parameter is true.
+// POST /admin/receipts/points/recompute
+func (api ReceiptsApi) HandleRecomputePoints(c *gin.Context) {
+	all := c.Query("all") == "true"
+
+	report, err := api.Database.RecomputePoints(all)
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error",
+		})
+
+		log.Printf("error while recomputing receipt points. %s", err)
+		return
+	}
+
+	c.JSON(200, report)
+}
+
+// List every rule set version along with the rules it contains
+// GET /admin/rule-sets
+func (api ReceiptsApi) HandleGetRuleSets(c *gin.Context) {
+	ruleSets := make([]gin.H, 0)
+
+	for _, version := range api.RuleSets.Versions() {
+		rules, _ := api.RuleSets.Get(version)
+
+		ruleSets = append(ruleSets, gin.H{
+			"version":     version,
+			"fingerprint": rules.Fingerprint(),
+			"active":      rules == api.RuleSets.Active,
+			"rules":       rules.RuleNames(),
+		})
+	}
+
+	c.JSON(200, gin.H{
+		"ruleSets": ruleSets,
+	})
+}
+
+type RescoreRequest struct {
+	RulesVersion     string `json:"rulesVersion" binding:"required"`
+	PurchaseDateFrom string `json:"purchaseDateFrom"`
+	PurchaseDateTo   string `json:"purchaseDateTo"`
+	DryRun           bool   `json:"dryRun"`
+}
+
+// Re-score the receipts purchased within an inclusive date range under a rule set version, returning the old and new points of
+// each receipt. The new points are not stored for a dry run.
+// POST /admin/receipts/points/rescore
+func (api ReceiptsApi) HandleRescorePoints(c *gin.Context) {
+	var request RescoreRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "The re-score request is invalid.",
+			"details": bindingValidationErrors(err),
+		})
+
+		return
+	}
+
+	rules, exists := api.RuleSets.Get(request.RulesVersion)
+
+	if !exists {
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("The re-score request is invalid. unknown rule set version %s", request.RulesVersion),
+		})
+
+		return
+	}
+
+	for _, date := range []string{request.PurchaseDateFrom, request.PurchaseDateTo} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("The re-score request is invalid. %s is not a date in the format YYYY-MM-DD", date),
+			})
+
+			return
+		}
+	}
+
+	// dates are always formatted as YYYY-MM-DD, so they can be compared as strings
+	report, err := api.Database.RescoreReceipts(rules, func(receipt *Receipt) bool {
+		return (request.PurchaseDateFrom == "" || receipt.PurchaseDate >= request.PurchaseDateFrom) &&
+			(request.PurchaseDateTo == "" || receipt.PurchaseDate <= request.PurchaseDateTo)
+	}, request.DryRun)
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error",
+		})
+
+		log.Printf("error while re-scoring receipt points. %s", err)
+		return
+	}
+
+	c.JSON(200, report)
+}
+
+type SimulationRequest struct {
+	// the rule set version to start from, which defaults to the active rules
+	RulesVersion string `json:"rulesVersion"`
+
+	// declarative rules, written like the rules of a rule file, which replace or add to the rules of the rule set version
+	Rules json.RawMessage `json:"rules"`
+
+	Retailer         string `json:"retailer"`
+	PurchaseDateFrom string `json:"purchaseDateFrom"`
+	PurchaseDateTo   string `json:"purchaseDateTo"`
+	MinTotal         string `json:"minTotal"`
+	MaxTotal         string `json:"maxTotal"`
+	BucketSize       int    `json:"bucketSize" binding:"min=0"`
+	Limit            int    `json:"limit" binding:"min=0,max=100"`
+}
+
+// Score the stored receipts under a candidate rule set without modifying them, returning the change in points, a histogram of
+// points before and after, the most affected retailers and the receipts whose points changed the most
+// POST /admin/receipts/points/simulate
+func (api ReceiptsApi) HandleSimulateRules(c *gin.Context) {
+	var request SimulationRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "The simulation request is invalid.",
+			"details": bindingValidationErrors(err),
+		})
+
+		return
+	}
+
+	base := api.Rules
+
+	if request.RulesVersion != "" {
+		var exists bool
+
+		if base, exists = api.RuleSets.Get(request.RulesVersion); !exists {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("The simulation request is invalid. unknown rule set version %s", request.RulesVersion),
+			})
+
+			return
+		}
+	}
+
+	rules := base
+
+	if len(request.Rules) > 0 {
+		definitions, err := ParseRuleFile(fmt.Appendf(nil, `{"rules": %s}`, request.Rules))
+
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error":   "The simulation request is invalid.",
+				"details": strings.Split(err.Error(), "\n"),
+			})
+
+			return
+		}
+
+		candidates := make([]Rule, 0, len(definitions))
+
+		for _, rule := range definitions {
+			candidates = append(candidates, rule)
+		}
+
+		rules = base.WithRules(candidates)
+	}
+
+	query := &ReceiptQuery{
+		Retailer:         request.Retailer,
+		PurchaseDateFrom: request.PurchaseDateFrom,
+		PurchaseDateTo:   request.PurchaseDateTo,
+	}
+
+	for _, date := range []string{request.PurchaseDateFrom, request.PurchaseDateTo} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("The simulation request is invalid. %s is not a date in the format YYYY-MM-DD", date),
+			})
+
+			return
+		}
+	}
+
+	for _, target := range []struct {
+		value  string
+		amount **Money
+	}{{request.MinTotal, &query.MinTotal}, {request.MaxTotal, &query.MaxTotal}} {
+		if target.value == "" {
+			continue
+		}
+
+		total, err := ParseMoney(target.value)
+
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("The simulation request is invalid. %s is not an amount such as 1.25", target.value),
+			})
+
+			return
+		}
+
+		*target.amount = &total
+	}
+
+	options := SimulationOptions{
+		BucketSize: request.BucketSize,
+		Limit:      request.Limit,
+	}
+
+	if options.BucketSize == 0 {
+		options.BucketSize = DefaultSimulationBucketSize
+	}
+
+	if options.Limit == 0 {
+		options.Limit = DefaultSimulationLimit
+	}
+
+	report, err := api.Database.SimulateRules(base, rules, query, options)
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error",
+		})
+
+		log.Printf("error while simulating scoring rules. %s", err)
+		return
+	}
+
+	c.JSON(200, report)
+}
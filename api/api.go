@@ -1,23 +1,105 @@
 package api
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ReceiptsApi struct {
+	Config   *Config
 	Router   *gin.Engine
 	Database *ReceiptDatabase
+	Rules    *RuleSet
+	RuleSets *RuleSetCatalog
 }
 
-func SetupApi() *ReceiptsApi {
+// Receipt and customer ids are GUIDs
+var idPattern = regexp.MustCompile(`^[{]?[0-9a-f]{8}-([0-9a-f]{4}-){3}[0-9a-f]{12}[}]?$`)
+
+func SetupApi(config *Config) *ReceiptsApi {
+	if err := ValidateConsistencyPolicy(config.ConsistencyPolicy); err != nil {
+		log.Fatalf("Error while configuring receipt consistency checks. %s", err)
+	}
+
+	if config.DuplicatePolicy != DuplicatePolicyReturn && config.DuplicatePolicy != DuplicatePolicyReject {
+		log.Fatalf("Error while configuring duplicate receipt handling. unknown duplicate policy %s", config.DuplicatePolicy)
+	}
+
+	if err := ValidateTimezones(config); err != nil {
+		log.Fatalf("Error while configuring receipt timezones. %s", err)
+	}
+
+	// configured rules are registered in a copy of the built-in rules, so that the built-in rules never change
+	registry := DefaultRuleRegistry()
+
+	// rules from the rule file may add new rules or replace built-in rules with the same name
+	if config.RulesFile != "" {
+		definitions, err := LoadRuleFile(config.RulesFile)
+
+		if err != nil {
+			log.Fatalf("Error while configuring scoring rules. %s", err)
+		}
+
+		for _, rule := range definitions {
+			registry.Register(rule)
+		}
+	}
+
+	windows, err := ParseTimeWindowRules(config.TimeWindowRules)
+
+	if err != nil {
+		log.Fatalf("Error while configuring time window rules. %s", err)
+	}
+
+	// time window rules may add new rules or replace a built-in rule with the same name, such as afternoon-purchase
+	for _, window := range windows {
+		registry.Register(window)
+	}
+
+	rules, err := registry.NewRuleSet(config.ScoringRules)
+
+	if err != nil {
+		log.Fatalf("Error while configuring scoring rules. %s", err)
+	}
+
+	rules.Version = config.RulesVersion
+
+	ruleSets, err := NewRuleSetCatalog(registry, rules, config.RuleSets)
+
+	if err != nil {
+		log.Fatalf("Error while configuring rule set versions. %s", err)
+	}
+
 	api := &ReceiptsApi{
+		Config:   config,
 		Router:   gin.Default(),
-		Database: SetupDatabase(),
+		Database: SetupDatabase(config, rules),
+		Rules:    rules,
+		RuleSets: ruleSets,
+	}
+
+	receipts, err := api.Database.GetAllReceipts()
+
+	if err != nil {
+		log.Fatalf("Error while checking rule set versions. %s", err)
+	}
+
+	if err := ruleSets.CheckReceipts(receipts); err != nil {
+		log.Fatalf("Error while checking rule set versions. %s", err)
 	}
 
 	api.Router.NoRoute(api.HandleNoRoute)
@@ -25,13 +107,63 @@ func SetupApi() *ReceiptsApi {
 	api.Router.GET("/status", api.HandleStatus)
 
 	api.Router.POST("/receipts/process", api.HandleCreateNewReceipt)
+	api.Router.POST("/receipts/process/batch", api.HandleCreateNewReceiptBatch)
+	api.Router.POST("/receipts/score", api.HandleScoreReceipt)
 	api.Router.GET("/receipts", api.HandleGetAllReceipts)
 	api.Router.GET("/receipts/:id", api.HandleGetReceiptById)
 	api.Router.GET("/receipts/:id/points", api.HandleGetReceiptPointsById)
+	api.Router.GET("/receipts/:id/points/breakdown", api.HandleGetReceiptPointsBreakdownById)
+
+	api.Router.POST("/customers", api.HandleCreateCustomer)
+	api.Router.GET("/customers/:id", api.HandleGetCustomerById)
+	api.Router.GET("/customers/:id/balance", api.HandleGetCustomerBalance)
+	api.Router.GET("/customers/:id/ledger", api.HandleGetCustomerLedger)
+	api.Router.GET("/customers/:id/holds/:holdId", api.HandleGetHold)
+
+	// customers have no credentials of their own, so endpoints that void or refund receipts or spend points require the admin
+	// token when one is configured. Without one, the API must only be reachable by trusted services.
+	points := api.Router.Group("/")
+
+	if config.AdminToken != "" {
+		points.Use(api.RequireAdminToken)
+	} else {
+		log.Printf("API_ADMIN_TOKEN is not set, so receipts can be voided and refunded and points spent without a token")
+	}
+
+	points.POST("/receipts/:id/void", api.HandleVoidReceipt)
+	points.POST("/receipts/:id/refunds", api.HandleCreateRefund)
+	points.POST("/customers/:id/redemptions", api.HandleCreateRedemption)
+	points.POST("/customers/:id/holds", api.HandleCreateHold)
+	points.POST("/customers/:id/holds/:holdId/capture", api.HandleCaptureHold)
+	points.POST("/customers/:id/holds/:holdId/release", api.HandleReleaseHold)
+
+	// admin endpoints are only available when an admin token is configured
+	if config.AdminToken != "" {
+		admin := api.Router.Group("/admin", api.RequireAdminToken)
+		admin.GET("/rule-sets", api.HandleGetRuleSets)
+		admin.POST("/receipts/points/recompute", api.HandleRecomputePoints)
+		admin.POST("/receipts/points/rescore", api.HandleRescorePoints)
+		admin.POST("/receipts/points/simulate", api.HandleSimulateRules)
+		admin.POST("/receipts/:id/reverse", api.HandleReverseReceiptPoints)
+	}
 
 	return api
 }
 
+// Release all resources held by the API, flushing any pending storage writes
+func (api ReceiptsApi) Close() error {
+	return api.Database.Close()
+}
+
+// Duplicate policies, which decide how to respond when the same receipt is submitted more than once
+const (
+	// respond with the id of the original receipt, as if it had just been created
+	DuplicatePolicyReturn = "return"
+
+	// respond with a 409 conflict that includes the id of the original receipt
+	DuplicatePolicyReject = "reject"
+)
+
 type Header struct {
 	ContentType *string `header:"content-type" binding:"required"`
 }
@@ -39,8 +171,21 @@ type Header struct {
 // Create a new receipt record
 // POST /receipts/process
 func (api ReceiptsApi) HandleCreateNewReceipt(c *gin.Context) {
+	if !requireJsonContentType(c) {
+		return
+	}
 
-	var input Receipt
+	// process each request at most once per idempotency key
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		api.handleIdempotentRequest(c, key, api.createReceipt)
+		return
+	}
+
+	c.JSON(api.createReceipt(c))
+}
+
+// Check that the request body is JSON, writing an error response if it is not
+func requireJsonContentType(c *gin.Context) bool {
 	var header Header
 
 	// bind to headers and perform basic check
@@ -49,7 +194,7 @@ func (api ReceiptsApi) HandleCreateNewReceipt(c *gin.Context) {
 			"error": "Missing content-type header.",
 		})
 
-		return
+		return false
 	}
 
 	if header.ContentType == nil || !strings.Contains(*header.ContentType, "application/json") {
@@ -57,144 +202,519 @@ func (api ReceiptsApi) HandleCreateNewReceipt(c *gin.Context) {
 			"error": "Unsupported content type. Only `application/json` is supported.",
 		})
 
-		return
+		return false
 	}
 
+	return true
+}
+
+// Bind and validate the receipt in the request body, returning the response body for a 400 if the receipt is invalid
+func (api ReceiptsApi) bindReceipt(c *gin.Context) (*Receipt, gin.H) {
+	var input Receipt
+
 	// bind to input and perform basic format validation
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{
-			"error": "The receipt is invalid.",
+		return nil, gin.H{
+			"error":   "The receipt is invalid.",
+			"details": bindingValidationErrors(err),
+		}
+	}
+
+	input.clearServerFields()
+
+	// return all validation errors if any were encountered
+	if errors := api.ValidateReceipt(&input); len(errors) > 0 {
+		return nil, gin.H{
+			"error":   "The receipt is invalid.",
+			"details": errors,
+		}
+	}
+
+	return &input, nil
+}
+
+// Validate and insert the receipt in the request body, returning the response status code and body
+func (api ReceiptsApi) createReceipt(c *gin.Context) (int, gin.H) {
+	input, invalid := api.bindReceipt(c)
+
+	if invalid != nil {
+		return 400, invalid
+	}
+
+	// insert a new receipt record to the database
+	id, err := api.Database.InsertReceipt(input)
+
+	// the same receipt has already been submitted
+	var duplicate *DuplicateReceiptError
+
+	if errors.As(err, &duplicate) {
+		log.Printf("receipt is a duplicate of existing receipt %s", duplicate.Id)
+
+		if api.Config.DuplicatePolicy == DuplicatePolicyReject {
+			return 409, gin.H{
+				"error": "The receipt has already been submitted.",
+				"id":    duplicate.Id,
+			}
+		}
+
+		return 200, gin.H{
+			"id": duplicate.Id,
+		}
+	}
+
+	var exists *ReceiptExistsError
+
+	if errors.As(err, &exists) {
+		return 409, gin.H{
+			"error": "A receipt already exists with this id.",
+			"id":    exists.Id,
+		}
+	}
+
+	if err != nil {
+		log.Printf("error while inserting receipt record into database. %s", err)
+
+		return 500, gin.H{
+			"error": "unknown error",
+		}
+	}
+
+	return 200, gin.H{
+		"id": id,
+	}
+}
+
+// Validate the receipt in the request body and calculate its points without storing it, so that points can be previewed
+// POST /receipts/score
+func (api ReceiptsApi) HandleScoreReceipt(c *gin.Context) {
+	if !requireJsonContentType(c) {
+		return
+	}
+
+	input, invalid := api.bindReceipt(c)
+
+	if invalid != nil {
+		c.JSON(400, invalid)
+		return
+	}
+
+	// the receipt is scored with the loyalty tier its customer would have for it
+	tier, err := api.Database.GetReceiptTier(input)
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error while calculating receipt points",
 		})
 
+		log.Printf("error while evaluating loyalty tier for a scored receipt. %s", err)
 		return
 	}
 
-	errors := make([]string, 0)
+	input.Tier = tier
 
-	// validate retailer
-	if match := regexp.MustCompile(`^[\w\s\-&]+$`).MatchString(input.Retailer); !match {
-		errors = append(errors, "invalid retailer")
+	breakdown, err := api.Rules.GetPointsBreakdown(input)
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error while calculating receipt points",
+		})
+
+		log.Printf("error while calculating points breakdown for a scored receipt. %s", err)
+		return
+	}
+
+	c.JSON(200, breakdown)
+}
+
+// Create several receipt records at once from a JSON array or newline delimited JSON
+// POST /receipts/process/batch
+func (api ReceiptsApi) HandleCreateNewReceiptBatch(c *gin.Context) {
+	if contentType := c.ContentType(); contentType != "application/json" && contentType != "application/x-ndjson" {
+		c.JSON(405, gin.H{
+			"error": "Unsupported content type. Only `application/json` and `application/x-ndjson` are supported.",
+		})
+
+		return
 	}
 
-	// validate purchase date
-	if match := regexp.MustCompile(`^[0-9]{4}\-[0-1][0-9]\-[0-3][0-9]$`).MatchString(input.PurchaseDate); !match {
-		errors = append(errors, "invalid purchaseDate value")
+	// the body is limited before it is read, including to hash it for an idempotency key
+	if api.Config.BatchMaxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(api.Config.BatchMaxBytes))
 	}
 
-	// validate purchase time
-	if match := regexp.MustCompile(`^[0-2][0-9]:[0-5][0-9]$`).MatchString(input.PurchaseTime); !match {
-		errors = append(errors, "invalid purchaseTime value")
+	// process each request at most once per idempotency key
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		api.handleIdempotentRequest(c, key, api.createReceiptBatch)
+		return
 	}
 
-	// validate total
-	if match := regexp.MustCompile(`^\d+\.\d{2}$`).MatchString(input.PurchaseTotal); !match {
-		errors = append(errors, "invalid total value")
+	c.JSON(api.createReceiptBatch(c))
+}
+
+// Validate every receipt in the request body and insert all valid receipts in a single transaction, returning the response
+// status code and body
+func (api ReceiptsApi) createReceiptBatch(c *gin.Context) (int, gin.H) {
+	inputs, err := DecodeReceiptBatch(c.Request.Body, c.ContentType() == "application/x-ndjson", api.Config.BatchMaxReceipts)
+
+	if errors.Is(err, ErrBatchTooLarge) {
+		return 413, gin.H{
+			"error": fmt.Sprintf("The batch is invalid. %s", err),
+		}
 	}
 
-	// validate receipt item count
-	if len(input.Items) < 1 {
-		errors = append(errors, "at least one receipt item must be provided")
+	if err != nil {
+		return 400, gin.H{
+			"error": fmt.Sprintf("The batch is invalid. %s", err),
+		}
 	}
 
-	// validate each receipt item
-	for i, item := range input.Items {
-		// validate receipt item description
-		if match := regexp.MustCompile(`^[\w\s\-]+$`).MatchString(item.ShortDescription); !match {
-			errors = append(errors, fmt.Sprintf("invalid shortDescription value for receipt item %d", i))
+	results := make([]BatchResult, len(inputs))
+	receipts := make([]*Receipt, 0, len(inputs))
+	positions := make([]int, 0, len(inputs))
+
+	// validate each receipt with the same rules as a single receipt
+	for i, input := range inputs {
+		results[i].Index = i
+
+		details := input.Errors
+
+		if input.Receipt != nil {
+			input.Receipt.clearServerFields()
+			details = api.ValidateReceipt(input.Receipt)
 		}
 
-		// validate receipt item price
-		if match := regexp.MustCompile(`^\d+\.\d{2}$`).MatchString(item.Price); !match {
-			errors = append(errors, fmt.Sprintf("invalid price value for receipt item %d", i))
+		if len(details) > 0 {
+			results[i].Error = "The receipt is invalid."
+			results[i].Details = details
+			continue
 		}
+
+		receipts = append(receipts, input.Receipt)
+		positions = append(positions, i)
 	}
 
-	// return all validation errors if any were encountered
-	if len(errors) > 0 {
+	rejected, err := api.Database.InsertReceipts(receipts)
+
+	if err != nil {
+		log.Printf("error while inserting receipt batch into database. %s", err)
+
+		return 500, gin.H{
+			"error": "unknown error",
+		}
+	}
+
+	inserted := 0
+	duplicates := 0
+
+	for j, receipt := range receipts {
+		result := &results[positions[j]]
+
+		var duplicate *DuplicateReceiptError
+
+		if errors.As(rejected[j], &duplicate) {
+			result.Id = duplicate.Id
+			result.Duplicate = true
+
+			if api.Config.DuplicatePolicy == DuplicatePolicyReject {
+				result.Error = "The receipt has already been submitted."
+			} else {
+				duplicates++
+			}
+
+			continue
+		}
+
+		if rejected[j] != nil {
+			result.Error = "A receipt already exists with this id."
+			continue
+		}
+
+		result.Id = receipt.GetId()
+		inserted++
+	}
+
+	// every receipt is counted once, so that the totals match the results. Duplicates answered with the id of the original receipt
+	// are neither inserted nor rejected.
+	return 200, gin.H{
+		"inserted":   inserted,
+		"duplicates": duplicates,
+		"rejected":   len(inputs) - inserted - duplicates,
+		"results":    results,
+	}
+}
+
+// Process a request at most once per Idempotency-Key. Retries with the same key and request body receive the original response,
+// while reusing a key for a different request is rejected.
+func (api ReceiptsApi) handleIdempotentRequest(c *gin.Context, key string, process func(c *gin.Context) (int, gin.H)) {
+	if len(key) > 255 {
 		c.JSON(400, gin.H{
-			"error": fmt.Sprintf("The receipt is invalid. %s", strings.Join(errors, ", ")),
+			"error": "The Idempotency-Key header must be at most 255 characters.",
 		})
 
 		return
 	}
 
-	// insert a new receipt record to the database
-	id, err := api.Database.InsertReceipt(&input)
+	body, err := io.ReadAll(c.Request.Body)
+
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		c.JSON(413, gin.H{
+			"error": fmt.Sprintf("The request body must be at most %d bytes.", tooLarge.Limit),
+		})
+
+		return
+	}
+
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": "Unable to read request body.",
+		})
+
+		return
+	}
+
+	// put the body back so that it can still be bound by the request handler
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	// the same key used on a different endpoint, or for a different resource, is a different request
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	hash.Write(body)
+	requestHash := hex.EncodeToString(hash.Sum(nil))
+
+	record, reserved, err := api.Database.ReserveIdempotencyKey(key, requestHash, time.Now().Add(api.Config.IdempotencyWindow))
 
 	if err != nil {
 		c.JSON(500, gin.H{
 			"error": "unknown error",
 		})
 
-		log.Printf("error while inserting receipt record into database. %s", err)
+		log.Printf("error while reserving idempotency key %s. %s", key, err)
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"id": id,
-	})
+	if !reserved {
+		if record.RequestHash != requestHash {
+			c.JSON(422, gin.H{
+				"error": "The Idempotency-Key has already been used for a different request.",
+			})
+		} else if record.Pending {
+			c.JSON(409, gin.H{
+				"error": "A request with this Idempotency-Key is still being processed.",
+			})
+		} else {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.StatusCode, "application/json; charset=utf-8", record.Response)
+		}
+
+		return
+	}
+
+	status, response := process(c)
+
+	// server errors are not stored so that the request can be retried
+	if status >= 500 {
+		if err := api.Database.ReleaseIdempotencyKey(record); err != nil {
+			log.Printf("error while releasing idempotency key %s. %s", key, err)
+		}
+
+		c.JSON(status, response)
+		return
+	}
+
+	encoded, err := json.Marshal(response)
+
+	if err == nil {
+		err = api.Database.CompleteIdempotencyKey(record, status, encoded)
+	}
+
+	if err != nil {
+		log.Printf("error while storing response for idempotency key %s. %s", key, err)
+
+		if err := api.Database.ReleaseIdempotencyKey(record); err != nil {
+			log.Printf("error while releasing idempotency key %s. %s", key, err)
+		}
+	}
+
+	c.JSON(status, response)
 }
 
-// Query all receipts
+// Query a page of receipts, optionally sorted and filtered
 // GET /receipts
 func (api ReceiptsApi) HandleGetAllReceipts(c *gin.Context) {
-	receipts, err := api.Database.GetAllReceipts()
+	query, errors := parseReceiptQuery(c)
+
+	if len(errors) > 0 {
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("Invalid query. %s", strings.Join(errors, ", ")),
+		})
+
+		return
+	}
+
+	page, err := api.Database.QueryReceipts(query)
 
 	if err != nil {
-		c.JSON(500, gin.H{
-			"error": "error while querying all receipts",
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("Invalid query. %s", err),
 		})
 
-		log.Printf("error while querying all receipts. %s", err)
+		log.Printf("error while querying receipts. %s", err)
 		return
 	}
 
-	c.JSON(200, receipts)
+	c.JSON(200, page)
+}
+
+// Parse the pagination, sorting and filtering options for the receipts query from the query string
+func parseReceiptQuery(c *gin.Context) (*ReceiptQuery, []string) {
+	errors := make([]string, 0)
+
+	query := &ReceiptQuery{
+		Limit:            DefaultQueryLimit,
+		Cursor:           c.Query("cursor"),
+		SortBy:           c.DefaultQuery("sort", SortByPurchaseDatetime),
+		Retailer:         c.Query("retailer"),
+		PurchaseDateFrom: c.Query("purchaseDateFrom"),
+		PurchaseDateTo:   c.Query("purchaseDateTo"),
+	}
+
+	if value, exists := c.GetQuery("limit"); exists {
+		if limit, err := strconv.Atoi(value); err != nil || limit < 1 || limit > MaxQueryLimit {
+			errors = append(errors, fmt.Sprintf("limit must be between 1 and %d", MaxQueryLimit))
+		} else {
+			query.Limit = limit
+		}
+	}
+
+	if _, exists := receiptSortIndexes[query.SortBy]; !exists {
+		errors = append(errors, fmt.Sprintf("sort must be one of %s, %s or %s", SortByPurchaseDatetime, SortByPoints, SortByTotal))
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+		query.Descending = false
+	case "desc":
+		query.Descending = true
+	default:
+		errors = append(errors, "order must be one of asc or desc")
+	}
+
+	for name, value := range map[string]string{"purchaseDateFrom": query.PurchaseDateFrom, "purchaseDateTo": query.PurchaseDateTo} {
+		if value == "" {
+			continue
+		}
+
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			errors = append(errors, fmt.Sprintf("%s must be a date such as 2022-01-01", name))
+		}
+	}
+
+	for name, target := range map[string]**Money{"minTotal": &query.MinTotal, "maxTotal": &query.MaxTotal} {
+		if value, exists := c.GetQuery(name); exists {
+			if total, err := ParseMoney(value); err != nil {
+				errors = append(errors, fmt.Sprintf("%s must be an amount such as 1.25", name))
+			} else {
+				*target = &total
+			}
+		}
+	}
+
+	if value, exists := c.GetQuery("minPoints"); exists {
+		if points, err := strconv.Atoi(value); err != nil {
+			errors = append(errors, "minPoints must be an integer")
+		} else {
+			query.MinPoints = &points
+		}
+	}
+
+	// maps are iterated in a random order, so keep the error messages stable
+	sort.Strings(errors)
+
+	return query, errors
 }
 
 // Query a single receipt by ID
 // GET /receipts/{id}
 func (api ReceiptsApi) HandleGetReceiptById(c *gin.Context) {
-	id := c.Param("id")
+	receipt, found := api.lookupReceipt(c)
 
-	// validate the id format (GUID)
-	if match := regexp.MustCompile(`^[{]?[0-9a-f]{8}-([0-9a-f]{4}-){3}[0-9a-f]{12}[}]?$`).MatchString(id); !match {
-		c.JSON(400, gin.H{
-			"error": "invalid receipt id format",
+	if !found {
+		return
+	}
+
+	c.JSON(200, receipt)
+}
+
+// Query a single receipt by ID and return the number of points
+// GET /receipts/{id}/points
+func (api ReceiptsApi) HandleGetReceiptPointsById(c *gin.Context) {
+	receipt, found := api.lookupReceipt(c)
+
+	if !found {
+		return
+	}
+
+	// points are calculated when the receipt is inserted
+	if receipt.Points == nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error while calculating receipt points",
 		})
 
-		log.Printf("invalid id %s provided", id)
+		log.Printf("receipt with id %s has not been scored", receipt.GetId())
 		return
 	}
 
-	// lookup receipt by ID
-	receipt, err := api.Database.GetReceiptById(id)
+	c.JSON(200, gin.H{
+		"points": *receipt.Points,
+	})
+}
+
+// Query a single receipt by ID and return the points contributed by each scoring rule
+// GET /receipts/{id}/points/breakdown
+func (api ReceiptsApi) HandleGetReceiptPointsBreakdownById(c *gin.Context) {
+	receipt, found := api.lookupReceipt(c)
+
+	if !found {
+		return
+	}
+
+	// explain the points using the rule set that awarded them, falling back to the current rules if that version is unknown
+	rules, exists := api.RuleSets.Get(receipt.RulesVersion)
+
+	if !exists {
+		rules = api.Rules
+	}
+
+	// calculate receipt points along with the result of each rule, leaving out any refunded items
+	breakdown, err := rules.GetReceiptPointsBreakdown(receipt)
 
 	if err != nil {
-		c.JSON(404, gin.H{
-			"error": "no receipt found",
+		c.JSON(500, gin.H{
+			"error": "unknown error while calculating receipt points",
 		})
 
-		log.Printf("no receipt found for id %s. %s", id, err)
+		log.Printf("error while calculating points breakdown for id %s. %s", receipt.GetId(), err)
 		return
 	}
 
-	c.JSON(200, receipt)
+	c.JSON(200, breakdown)
 }
 
-// Query a single receipt by ID and return the number of points
-// GET /receipts/{id}/points
-func (api ReceiptsApi) HandleGetReceiptPointsById(c *gin.Context) {
+// Validate the receipt id path parameter and lookup the matching receipt, writing an error response if the id is invalid or
+// no receipt is found
+func (api ReceiptsApi) lookupReceipt(c *gin.Context) (*Receipt, bool) {
 	id := c.Param("id")
 
 	// validate the id format (GUID)
-	if match := regexp.MustCompile(`^[{]?[0-9a-f]{8}-([0-9a-f]{4}-){3}[0-9a-f]{12}[}]?$`).MatchString(id); !match {
+	if match := idPattern.MatchString(id); !match {
 		c.JSON(400, gin.H{
 			"error": "invalid receipt id format",
 		})
 
 		log.Printf("invalid id %s provided", id)
-		return
+		return nil, false
 	}
 
 	// lookup receipt by ID
@@ -206,24 +726,10 @@ func (api ReceiptsApi) HandleGetReceiptPointsById(c *gin.Context) {
 		})
 
 		log.Printf("no receipt found for id %s. %s", id, err)
-		return
-	}
-
-	// calculate receipt points
-	points, err := receipt.GetPoints()
-
-	if err != nil {
-		c.JSON(500, gin.H{
-			"error": "unknown error while calculating receipt points",
-		})
-
-		log.Printf("error while calculating points for id %s. %s", id, err)
-		return
+		return nil, false
 	}
 
-	c.JSON(200, gin.H{
-		"points": points,
-	})
+	return receipt, true
 }
 
 func (api ReceiptsApi) HandleNoRoute(c *gin.Context) {
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Setup an API backed by an in-memory database, with the configuration changed by configure if given
+func setupTestApi(t *testing.T, configure func(config *Config)) *ReceiptsApi {
+	gin.SetMode(gin.TestMode)
+
+	config := &Config{
+		StorageBackend:    StorageBackendMemory,
+		RulesVersion:      "1",
+		DefaultTimezone:   "UTC",
+		ConsistencyPolicy: ConsistencyPolicyFlag,
+		DuplicatePolicy:   DuplicatePolicyReturn,
+		BatchMaxReceipts:  100,
+		HoldDuration:      15 * time.Minute,
+	}
+
+	if configure != nil {
+		configure(config)
+	}
+
+	api := SetupApi(config)
+
+	t.Cleanup(func() {
+		api.Close()
+	})
+
+	return api
+}
+
+// Send a request with a JSON body to the API, returning the response status code and decoded body
+func performRequest(t *testing.T, api *ReceiptsApi, method string, path string, body string) (int, map[string]any) {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	api.Router.ServeHTTP(recorder, request)
+
+	var response map[string]any
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unable to decode response body %s. %s", recorder.Body.String(), err)
+	}
+
+	return recorder.Code, response
+}
+
+func TestCreateReceiptIgnoresServerFields(t *testing.T) {
+	api := setupTestApi(t, nil)
+
+	// a receipt can't choose its own id, points or status, so it can't replace an existing receipt
+	body := `{"id": "` + exampleIdA + `", "retailer": "Target", "purchaseDate": "2022-01-02", "purchaseTime": "13:14",
+		"total": "1.25", "items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}],
+		"points": 1000, "status": "voided", "basePoints": 1000}`
+
+	status, response := performRequest(t, api, "POST", "/receipts/process", body)
+
+	if status != 200 || response["id"] == exampleIdA {
+		t.Fatalf("expected a new receipt id, but received %d %v", status, response)
+	}
+
+	created, _ := api.Database.GetReceiptById(response["id"].(string))
+
+	if *created.Points != 31 || created.Status != ReceiptStatusActive {
+		t.Errorf("expected an active receipt with 31 points, but received %d %s", *created.Points, created.Status)
+	}
+
+	if original, _ := api.Database.GetReceiptById(exampleIdA); original.Retailer != "Walgreens" {
+		t.Errorf("expected example receipt A to be unchanged, but received %s", original.Retailer)
+	}
+
+	// receipts inserted with the id of an existing receipt are rejected rather than overwriting it
+	id := exampleIdA
+	receipt := &Receipt{Id: &id, Retailer: "Target", PurchaseDate: "2022-01-02", PurchaseTime: "13:15", PurchaseTotal: "1.25",
+		Items: []ReceiptItem{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}}}
+
+	var exists *ReceiptExistsError
+
+	if _, err := api.Database.InsertReceipt(receipt); !errors.As(err, &exists) {
+		t.Errorf("expected receipt exists error, but received %v", err)
+	}
+}
+
+func TestScoreReceipt(t *testing.T) {
+	api := setupTestApi(t, func(config *Config) {
+		config.LoyaltyTiers = "bronze=0:1;silver=50:1.25"
+		config.LoyaltyTierBasis = TierBasisPoints
+		config.LoyaltyTierWindow = 24 * time.Hour
+	})
+
+	// two receipts of 31 points earlier in the day put the customer in the silver tier
+	customer, _ := api.Database.InsertCustomer(&CustomerInput{Name: "Ada"})
+	insertCustomerReceipt(t, api.Database, customer.Id, "09:13")
+	insertCustomerReceipt(t, api.Database, customer.Id, "10:13")
+
+	receipts, _ := api.Database.GetAllReceipts()
+	ledger, _ := api.Database.GetLedger(customer.Id, "", DefaultQueryLimit)
+
+	body := `{"retailer": "Target", "purchaseDate": "2022-01-02", "purchaseTime": "13:14", "total": "1.25",
+		"items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}], "customerId": "` + customer.Id + `"}`
+
+	status, response := performRequest(t, api, "POST", "/receipts/score", body)
+
+	if status != 200 {
+		t.Fatalf("expected 200, but received %d %v", status, response)
+	}
+
+	if breakdown, ok := response["breakdown"].([]any); !ok || len(breakdown) == 0 {
+		t.Errorf("expected the result of each rule, but received %v", response["breakdown"])
+	}
+
+	// the silver multiplier takes 31 base points to 38
+	tier, _ := response["tier"].(map[string]any)
+
+	if response["basePoints"] != float64(31) || response["points"] != float64(38) || tier["name"] != "silver" {
+		t.Errorf("expected 31 base points multiplied to 38 by the silver tier, but received %v", response)
+	}
+
+	// scoring a receipt never stores it or earns points
+	if after, _ := api.Database.GetAllReceipts(); len(after) != len(receipts) {
+		t.Errorf("expected %d stored receipts, but received %d", len(receipts), len(after))
+	}
+
+	if after, _ := api.Database.GetLedger(customer.Id, "", DefaultQueryLimit); len(after.Entries) != len(ledger.Entries) {
+		t.Errorf("expected %d ledger entries, but received %d", len(ledger.Entries), len(after.Entries))
+	}
+
+	if balance, _, _ := api.Database.GetCustomerBalance(customer.Id); balance != 62 {
+		t.Errorf("expected a balance of 62, but received %d", balance)
+	}
+}
+
+func TestPointsEndpointsRequireAdminToken(t *testing.T) {
+	api := setupTestApi(t, func(config *Config) {
+		config.AdminToken = "secret"
+	})
+
+	for _, path := range []string{"/receipts/" + exampleIdA + "/void", "/customers/unknown/redemptions", "/customers/unknown/holds"} {
+		if status, _ := performRequest(t, api, "POST", path, `{"points": 10}`); status != 401 {
+			t.Errorf("expected status 401 from %s without a token, but received %d", path, status)
+		}
+	}
+
+	request := httptest.NewRequest("POST", "/receipts/"+exampleIdA+"/void", strings.NewReader(`{}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer secret")
+
+	recorder := httptest.NewRecorder()
+	api.Router.ServeHTTP(recorder, request)
+
+	if recorder.Code != 200 {
+		t.Errorf("expected status 200 from void with the admin token, but received %d. %s", recorder.Code, recorder.Body.String())
+	}
+
+	// reading points doesn't require a token
+	if status, _ := performRequest(t, api, "GET", "/receipts/"+exampleIdA+"/points", ""); status != 200 {
+		t.Errorf("expected status 200 from points without a token, but received %d", status)
+	}
+}
+
+func TestCreateReceiptBatchTooLarge(t *testing.T) {
+	api := setupTestApi(t, func(config *Config) {
+		config.BatchMaxBytes = 64
+	})
+
+	body := "[" + strings.Repeat(`{"retailer": "Target"},`, 10) + `{"retailer": "Target"}]`
+
+	if status, response := performRequest(t, api, "POST", "/receipts/process/batch", body); status != 413 {
+		t.Errorf("expected status 413 for a batch over the byte limit, but received %d. %v", status, response)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// A single receipt decoded from a batch, or the errors that prevented it from being decoded
+type BatchInput struct {
+	Receipt *Receipt
+	Errors  []ValidationError
+}
+
+// The outcome for a single receipt in a batch, at the same index as the receipt in the request
+type BatchResult struct {
+	Index     int               `json:"index"`
+	Id        string            `json:"id,omitempty"`
+	Duplicate bool              `json:"duplicate,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Details   []ValidationError `json:"details,omitempty"`
+}
+
+// Returned when the body of a batch is larger than the configured limit
+var ErrBatchTooLarge = errors.New("batch is too large")
+
+// Decode a batch of receipts from either a JSON array or newline delimited JSON (one receipt per line). An error is only
+// returned when the batch as a whole can't be read; problems with a single receipt are reported on its input instead. Receipts
+// are read one at a time, and reading stops as soon as the batch has too many receipts.
+func DecodeReceiptBatch(body io.Reader, ndjson bool, maxReceipts int) ([]BatchInput, error) {
+	raw := make([]json.RawMessage, 0)
+
+	if ndjson {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			// blank lines are allowed between receipts
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				raw = append(raw, json.RawMessage(bytes.Clone(line)))
+			}
+
+			if len(raw) > maxReceipts {
+				break
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, batchReadError("unable to read receipts", err)
+		}
+	} else {
+		decoder := json.NewDecoder(body)
+
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, batchReadError("expected a JSON array of receipts", err)
+		}
+
+		for decoder.More() && len(raw) <= maxReceipts {
+			var receipt json.RawMessage
+
+			if err := decoder.Decode(&receipt); err != nil {
+				return nil, batchReadError("expected a JSON array of receipts", err)
+			}
+
+			raw = append(raw, receipt)
+		}
+
+		// the rest of a batch with too many receipts is never read, so only a complete batch must end the array
+		if len(raw) <= maxReceipts {
+			if _, err := decoder.Token(); err != nil {
+				return nil, batchReadError("expected a JSON array of receipts", err)
+			}
+		}
+	}
+
+	if len(raw) == 0 {
+		return nil, errors.New("at least one receipt must be provided")
+	}
+
+	if len(raw) > maxReceipts {
+		return nil, fmt.Errorf("at most %d receipts may be submitted in a single batch", maxReceipts)
+	}
+
+	inputs := make([]BatchInput, len(raw))
+
+	for i, data := range raw {
+		var receipt Receipt
+
+		// apply the same decoding and binding rules as a single receipt
+		err := json.Unmarshal(data, &receipt)
+
+		if err == nil {
+			err = binding.Validator.ValidateStruct(&receipt)
+		}
+
+		if err != nil {
+			inputs[i].Errors = bindingValidationErrors(err)
+			continue
+		}
+
+		inputs[i].Receipt = &receipt
+	}
+
+	return inputs, nil
+}
+
+// Describe an error while reading a batch, which is ErrBatchTooLarge when the body is larger than the configured limit
+func batchReadError(message string, err error) error {
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w, at most %d bytes may be submitted in a single batch", ErrBatchTooLarge, tooLarge.Limit)
+	}
+
+	if err == nil {
+		return errors.New(message)
+	}
+
+	return fmt.Errorf("%s. %s", message, err)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+const batchReceipt = `{"retailer":"Target","purchaseDate":"2022-01-05","purchaseTime":"10:00","total":"1.25","items":[{"shortDescription":"Pepsi","price":"1.25"}]}`
+
+func TestDecodeReceiptBatch(t *testing.T) {
+	ndjson := batchReceipt + "\n\n" + `{"retailer":"Target"}` + "\n" + `{"retailer":` + "\n"
+
+	inputs, err := DecodeReceiptBatch(strings.NewReader(ndjson), true, 10)
+
+	if err != nil {
+		t.Fatalf("unexpected error while decoding batch. %s", err)
+	}
+
+	if len(inputs) != 3 {
+		t.Fatalf("expected 3 inputs, but received %d instead", len(inputs))
+	}
+
+	if inputs[0].Receipt == nil || len(inputs[0].Errors) > 0 {
+		t.Errorf("expected input 0 to be decoded, but received errors %v", inputs[0].Errors)
+	}
+
+	for _, i := range []int{1, 2} {
+		if inputs[i].Receipt != nil || len(inputs[i].Errors) == 0 {
+			t.Errorf("expected input %d to have errors, but received none", i)
+		}
+	}
+
+	array := "[" + batchReceipt + "," + batchReceipt + "]"
+
+	if inputs, err := DecodeReceiptBatch(strings.NewReader(array), false, 10); err != nil || len(inputs) != 2 {
+		t.Errorf("expected 2 inputs, but received %d with error %v", len(inputs), err)
+	}
+
+	if _, err := DecodeReceiptBatch(strings.NewReader(array), false, 1); err == nil {
+		t.Errorf("expected error while decoding a batch that is too large, but received nil")
+	}
+
+	if _, err := DecodeReceiptBatch(strings.NewReader("[]"), false, 10); err == nil {
+		t.Errorf("expected error while decoding an empty batch, but received nil")
+	}
+
+	// reading stops once there are too many receipts, so the rest of the batch is never read
+	if _, err := DecodeReceiptBatch(strings.NewReader("["+batchReceipt+","+batchReceipt+", never read"), false, 1); err == nil || !strings.Contains(err.Error(), "at most 1 receipts") {
+		t.Errorf("expected error while decoding a batch that is too large, but received %v", err)
+	}
+
+	for _, body := range []string{"{}", "[" + batchReceipt, "[" + batchReceipt + "}"} {
+		if _, err := DecodeReceiptBatch(strings.NewReader(body), false, 10); err == nil {
+			t.Errorf("expected error while decoding batch %s, but received nil", body)
+		}
+	}
+}
+
+func TestInsertReceiptsDuplicateWithinBatch(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	inputs, _ := DecodeReceiptBatch(strings.NewReader(batchReceipt+"\n"+batchReceipt), true, 10)
+
+	receipts := []*Receipt{inputs[0].Receipt, inputs[1].Receipt}
+
+	rejected, err := db.InsertReceipts(receipts)
+
+	if err != nil {
+		t.Fatalf("unexpected error while inserting receipts. %s", err)
+	}
+
+	if rejected[0] != nil {
+		t.Errorf("expected first receipt to be inserted, but received %s", rejected[0])
+	}
+
+	duplicate, ok := rejected[1].(*DuplicateReceiptError)
+
+	if !ok || duplicate.Id != receipts[0].GetId() {
+		t.Errorf("expected duplicate of receipt %s, but received %v instead", receipts[0].GetId(), rejected[1])
+	}
+}
+
+func TestCreateReceiptBatchTotals(t *testing.T) {
+	// a new receipt, a duplicate of it and an invalid receipt. Duplicates are only rejected by the reject policy.
+	body := "[" + batchReceipt + "," + batchReceipt + `,{"retailer":"Target"}]`
+
+	expected := map[string][]float64{
+		DuplicatePolicyReturn: {1, 1, 1},
+		DuplicatePolicyReject: {1, 0, 2},
+	}
+
+	for policy, totals := range expected {
+		api := setupTestApi(t, func(config *Config) {
+			config.DuplicatePolicy = policy
+		})
+
+		status, response := performRequest(t, api, "POST", "/receipts/process/batch", body)
+
+		if status != 200 || response["inserted"] != totals[0] || response["duplicates"] != totals[1] || response["rejected"] != totals[2] {
+			t.Errorf("expected totals %v with the %s policy, but received %d %v", totals, policy, status, response)
+		}
+	}
+}
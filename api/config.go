@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +16,38 @@ type Config struct {
 	ServerBindAddress  string
 	ServerWriteTimeout time.Duration
 	ServerReadTimeout  time.Duration
+	ShutdownTimeout    time.Duration
+	ScoringRules       []string
+	RulesFile          string
+	RulesVersion       string
+	RuleSets           string
+	AdminToken         string
+	TimeWindowRules    string
+	DefaultTimezone    string
+	RetailerTimezones  map[string]string
+	PurchaseFutureSkew time.Duration
+	PurchaseMaxAge     time.Duration
+
+	ConsistencyPolicy       string
+	ConsistencyTaxTolerance int
+	DuplicatePolicy         string
+	IdempotencyWindow       time.Duration
+	BatchMaxReceipts        int
+	BatchMaxBytes           int
+
+	HoldDuration  time.Duration
+	SweepInterval time.Duration
+
+	PointsExpiryMonths  int
+	PointsExpiryWarning time.Duration
+
+	LoyaltyTiers      string
+	LoyaltyTierBasis  string
+	LoyaltyTierWindow time.Duration
+
+	StorageBackend          string
+	StoragePath             string
+	StorageSnapshotInterval time.Duration
 }
 
 func LoadConfig() *Config {
@@ -28,6 +61,38 @@ func LoadConfig() *Config {
 		ServerBindAddress:  fmt.Sprintf("%s:%d", host, port),
 		ServerWriteTimeout: GetEnvDuration("API_WRITE_TIMEOUT", 15*time.Second),
 		ServerReadTimeout:  GetEnvDuration("API_READ_TIMEOUT", 15*time.Second),
+		ShutdownTimeout:    GetEnvDuration("API_SHUTDOWN_TIMEOUT", 30*time.Second),
+		ScoringRules:       GetEnvStringSlice("API_SCORING_RULES", []string{}),
+		RulesFile:          GetEnvString("API_RULES_FILE", ""),
+		RulesVersion:       GetEnvString("API_RULES_VERSION", "1"),
+		RuleSets:           GetEnvString("API_RULE_SETS", ""),
+		AdminToken:         GetEnvString("API_ADMIN_TOKEN", ""),
+		TimeWindowRules:    GetEnvString("API_TIME_WINDOW_RULES", ""),
+		DefaultTimezone:    GetEnvString("API_DEFAULT_TIMEZONE", "UTC"),
+		RetailerTimezones:  GetEnvStringMap("API_RETAILER_TIMEZONES", map[string]string{}),
+		PurchaseFutureSkew: GetEnvDuration("API_PURCHASE_FUTURE_SKEW", 24*time.Hour),
+		PurchaseMaxAge:     GetEnvDuration("API_PURCHASE_MAX_AGE", 0),
+
+		ConsistencyPolicy:       GetEnvString("API_CONSISTENCY_POLICY", ConsistencyPolicyFlag),
+		ConsistencyTaxTolerance: GetEnvInt("API_CONSISTENCY_TAX_TOLERANCE", 10),
+		DuplicatePolicy:         GetEnvString("API_DUPLICATE_POLICY", DuplicatePolicyReturn),
+		IdempotencyWindow:       GetEnvDuration("API_IDEMPOTENCY_WINDOW", 24*time.Hour),
+		BatchMaxReceipts:        GetEnvInt("API_BATCH_MAX_RECEIPTS", 5000),
+		BatchMaxBytes:           GetEnvInt("API_BATCH_MAX_BYTES", 32*1024*1024),
+
+		HoldDuration:  GetEnvDuration("API_HOLD_DURATION", 15*time.Minute),
+		SweepInterval: GetEnvDuration("API_SWEEP_INTERVAL", time.Minute),
+
+		PointsExpiryMonths:  GetEnvInt("API_POINTS_EXPIRY_MONTHS", 0),
+		PointsExpiryWarning: GetEnvDuration("API_POINTS_EXPIRY_WARNING", 30*24*time.Hour),
+
+		LoyaltyTiers:      GetEnvString("API_LOYALTY_TIERS", "bronze=0:1;silver=500:1.25;gold=1500:1.5"),
+		LoyaltyTierBasis:  GetEnvString("API_LOYALTY_TIER_BASIS", TierBasisPoints),
+		LoyaltyTierWindow: GetEnvDuration("API_LOYALTY_TIER_WINDOW", 365*24*time.Hour),
+
+		StorageBackend:          GetEnvString("API_STORAGE_BACKEND", StorageBackendMemory),
+		StoragePath:             GetEnvString("API_STORAGE_PATH", "data"),
+		StorageSnapshotInterval: GetEnvDuration("API_STORAGE_SNAPSHOT_INTERVAL", 5*time.Minute),
 	}
 }
 
@@ -40,6 +105,43 @@ func GetEnvString(key string, defaultValue string) string {
 	}
 }
 
+func GetEnvStringSlice(key string, defaultValue []string) []string {
+	if value, exists := os.LookupEnv(key); exists {
+		values := make([]string, 0)
+
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+
+		return values
+	} else {
+		log.Printf("Missing environment variable %s, defaulting to %s instead.", key, strings.Join(defaultValue, ","))
+		return defaultValue
+	}
+}
+
+func GetEnvStringMap(key string, defaultValue map[string]string) map[string]string {
+	if _, exists := os.LookupEnv(key); exists {
+		values := make(map[string]string)
+
+		// keys are matched case-insensitively, eg. Target=America/Chicago,Walgreens=-05:00
+		for _, part := range GetEnvStringSlice(key, []string{}) {
+			if name, value, ok := strings.Cut(part, "="); ok {
+				values[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+			} else {
+				log.Printf("Ignoring invalid entry %s in environment variable %s.", part, key)
+			}
+		}
+
+		return values
+	} else {
+		log.Printf("Missing environment variable %s, defaulting to %v instead.", key, defaultValue)
+		return defaultValue
+	}
+}
+
 func GetEnvInt(key string, defaultValue int) int {
 	if value, exists := os.LookupEnv(key); exists {
 		if intValue, error := strconv.Atoi(value); error == nil {
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Consistency policies, which decide what happens when the item prices do not add up to the receipt total
+const (
+	// reject any receipt where the item prices do not add up to exactly the total
+	ConsistencyPolicyStrict = "strict"
+
+	// reject receipts unless the total is between the item sum and the item sum plus the tax tolerance
+	ConsistencyPolicyTolerance = "tolerance"
+
+	// accept every receipt, but flag receipts where the total is outside of the tax tolerance
+	ConsistencyPolicyFlag = "flag"
+)
+
+// The result of comparing the sum of the item prices to the receipt total, recorded when a receipt is submitted
+type ReceiptConsistency struct {
+	Policy      string `json:"policy"`
+	ItemsTotal  string `json:"itemsTotal"`
+	Discrepancy string `json:"discrepancy"`
+	Flagged     bool   `json:"flagged"`
+}
+
+// Check that the configured consistency policy is known
+func ValidateConsistencyPolicy(policy string) error {
+	switch policy {
+	case ConsistencyPolicyStrict, ConsistencyPolicyTolerance, ConsistencyPolicyFlag:
+		return nil
+	default:
+		return fmt.Errorf("unknown consistency policy %s", policy)
+	}
+}
+
+// Compare the sum of the item prices to the receipt total. The discrepancy is the total minus the item sum, so a positive
+// discrepancy is usually tax. Returns the consistency result along with whether the receipt should be rejected under the policy.
+func CheckConsistency(receipt *Receipt, policy string, tolerancePercent int) (*ReceiptConsistency, bool, error) {
+	total, err := receipt.GetPurchaseTotal()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	var itemsTotal Money
+
+	for i := range receipt.Items {
+		price, err := receipt.Items[i].GetPrice()
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if itemsTotal, err = itemsTotal.Add(price); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// prices and totals are never negative, so the difference can't overflow
+	discrepancy := total - itemsTotal
+
+	// compare as discrepancy / itemsTotal <= tolerancePercent / 100 to avoid rounding, using big integers since the products
+	// of large amounts don't fit in an int64
+	allowed := new(big.Int).Mul(big.NewInt(itemsTotal.Cents()), big.NewInt(int64(tolerancePercent)))
+	scaled := new(big.Int).Mul(big.NewInt(discrepancy.Cents()), big.NewInt(100))
+	withinTolerance := discrepancy >= 0 && scaled.Cmp(allowed) <= 0
+
+	consistency := &ReceiptConsistency{
+		Policy:      policy,
+		ItemsTotal:  itemsTotal.String(),
+		Discrepancy: discrepancy.String(),
+	}
+
+	switch policy {
+	case ConsistencyPolicyStrict:
+		consistency.Flagged = discrepancy != 0
+		return consistency, consistency.Flagged, nil
+	case ConsistencyPolicyTolerance:
+		consistency.Flagged = !withinTolerance
+		return consistency, consistency.Flagged, nil
+	default:
+		consistency.Flagged = !withinTolerance
+		return consistency, false, nil
+	}
+}
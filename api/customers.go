@@ -0,0 +1,225 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// A customer that earns points by submitting receipts
+type Customer struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CustomerInput struct {
+	Name  string `json:"name" binding:"required,max=200"`
+	Email string `json:"email" binding:"omitempty,email"`
+}
+
+// Insert a new customer, generating its id
+func (db ReceiptDatabase) InsertCustomer(input *CustomerInput) (*Customer, error) {
+	customer := &Customer{
+		Id:        uuid.New().String(),
+		Name:      input.Name,
+		Email:     input.Email,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	if err := txn.Insert("customer", customer); err != nil {
+		return nil, fmt.Errorf("unable to insert customer because of unknown error. %s", err)
+	}
+
+	if err := db.Storage.Append(StorageRecord{Type: StorageRecordCustomer, Customer: customer}); err != nil {
+		return nil, fmt.Errorf("unable to persist customer. %s", err)
+	}
+
+	txn.Commit()
+
+	return customer, nil
+}
+
+// Get a customer by ID
+func (db ReceiptDatabase) GetCustomerById(id string) (*Customer, error) {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	raw, err := txn.First("customer", "id", id)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying database for customer %s. %s", id, err)
+	}
+
+	if raw == nil {
+		return nil, fmt.Errorf("no customer exists with id %s", id)
+	}
+
+	return raw.(*Customer), nil
+}
+
+// Create a new customer
+// POST /customers
+func (api ReceiptsApi) HandleCreateCustomer(c *gin.Context) {
+	var input CustomerInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{
+			"error":   "The customer is invalid.",
+			"details": bindingValidationErrors(err),
+		})
+
+		return
+	}
+
+	customer, err := api.Database.InsertCustomer(&input)
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error",
+		})
+
+		log.Printf("error while inserting customer record into database. %s", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"id": customer.Id,
+	})
+}
+
+// Query a single customer by ID
+// GET /customers/{id}
+func (api ReceiptsApi) HandleGetCustomerById(c *gin.Context) {
+	if customer, found := api.lookupCustomer(c); found {
+		c.JSON(200, customer)
+	}
+}
+
+// Query the current spendable points balance of a customer, along with the points set aside by active holds, the points that
+// expire soon and the current loyalty tier of the customer
+// GET /customers/{id}/balance
+func (api ReceiptsApi) HandleGetCustomerBalance(c *gin.Context) {
+	customer, found := api.lookupCustomer(c)
+
+	if !found {
+		return
+	}
+
+	balance, held, err := api.Database.GetCustomerBalance(customer.Id)
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error",
+		})
+
+		log.Printf("error while querying balance for customer %s. %s", customer.Id, err)
+		return
+	}
+
+	expiring, err := api.Database.GetExpiringPoints(customer.Id, time.Now().UTC())
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error",
+		})
+
+		log.Printf("error while querying expiring points for customer %s. %s", customer.Id, err)
+		return
+	}
+
+	tier, err := api.Database.GetCustomerTier(customer.Id, time.Now().UTC())
+
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": "unknown error",
+		})
+
+		log.Printf("error while evaluating loyalty tier for customer %s. %s", customer.Id, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"customerId":   customer.Id,
+		"balance":      balance,
+		"held":         held,
+		"expiringSoon": expiring,
+		"tier":         tier,
+	})
+}
+
+// Query a page of the points ledger of a customer, oldest entry first
+// GET /customers/{id}/ledger
+func (api ReceiptsApi) HandleGetCustomerLedger(c *gin.Context) {
+	customer, found := api.lookupCustomer(c)
+
+	if !found {
+		return
+	}
+
+	limit := DefaultQueryLimit
+
+	if value, exists := c.GetQuery("limit"); exists {
+		var err error
+
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > MaxQueryLimit {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("Invalid query. limit must be between 1 and %d", MaxQueryLimit),
+			})
+
+			return
+		}
+	}
+
+	page, err := api.Database.GetLedger(customer.Id, c.Query("cursor"), limit)
+
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("Invalid query. %s", err),
+		})
+
+		log.Printf("error while querying ledger for customer %s. %s", customer.Id, err)
+		return
+	}
+
+	c.JSON(200, page)
+}
+
+// Validate the customer id path parameter and lookup the matching customer, writing an error response if the id is invalid or
+// no customer is found
+func (api ReceiptsApi) lookupCustomer(c *gin.Context) (*Customer, bool) {
+	id := c.Param("id")
+
+	if !idPattern.MatchString(id) {
+		c.JSON(400, gin.H{
+			"error": "invalid customer id format",
+		})
+
+		log.Printf("invalid customer id %s provided", id)
+		return nil, false
+	}
+
+	customer, err := api.Database.GetCustomerById(id)
+
+	if err != nil {
+		c.JSON(404, gin.H{
+			"error": "no customer found",
+		})
+
+		log.Printf("no customer found for id %s. %s", id, err)
+		return nil, false
+	}
+
+	return customer, true
+}
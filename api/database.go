@@ -1,24 +1,94 @@
 package api
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-memdb"
 )
 
 type ReceiptDatabase struct {
-	MemDB *memdb.MemDB
+	MemDB   *memdb.MemDB
+	Storage ReceiptStorage
+	Rules   *RuleSet
+
+	// when the points earned by a receipt expire
+	Expiration ExpirationPolicy
+
+	// the loyalty tiers that multiply the points earned by customers
+	Tiers TierPolicy
+
+	// the indexers used to sort receipts, keyed by index name
+	sortIndexes map[string]*receiptSortIndex
+
+	// serializes writes so that the order of records in storage always matches the order of commits, and so that a snapshot
+	// never misses a write
+	writeLock *sync.Mutex
+	stop      chan struct{}
+	workers   *sync.WaitGroup
 }
 
 // Setup and initialize the MemDB database
-func SetupDatabase() *ReceiptDatabase {
-	// Setup a basic schema that enables querying of receipts by Id
+func SetupDatabase(config *Config, rules *RuleSet) *ReceiptDatabase {
+	sortIndexes := map[string]*receiptSortIndex{
+		"purchase_datetime":          {key: purchaseDatetimeKey},
+		"retailer_purchase_datetime": {key: purchaseDatetimeKey, retailer: true},
+		"points":                     {key: storedPointsKey},
+		"retailer_points":            {key: storedPointsKey, retailer: true},
+		"total":                      {key: purchaseTotalKey},
+		"retailer_total":             {key: purchaseTotalKey, retailer: true},
+	}
+
+	// Setup a basic schema that enables querying of receipts by Id, along with indexes used to sort and filter receipts
+	receiptIndexes := map[string]*memdb.IndexSchema{
+		"id": {
+			Name:    "id",
+			Unique:  true,
+			Indexer: &memdb.StringFieldIndex{Field: "Id"},
+		},
+	}
+
+	// receipts with the same content are rejected by InsertReceipt, so fingerprints are unique
+	receiptIndexes["fingerprint"] = &memdb.IndexSchema{
+		Name:    "fingerprint",
+		Unique:  true,
+		Indexer: &receiptFingerprintIndex{},
+	}
+
+	// sort keys always end with the receipt id, so they are unique
+	for name, indexer := range sortIndexes {
+		receiptIndexes[name] = &memdb.IndexSchema{
+			Name:    name,
+			Unique:  true,
+			Indexer: indexer,
+		}
+	}
+
+	// records are never modified once they are inserted, since readers use them without holding a lock. A change inserts an
+	// updated copy that replaces the original, and the copy must not share anything it changes, such as receipt items.
 	schema := &memdb.DBSchema{
 		Tables: map[string]*memdb.TableSchema{
 			"receipt": {
-				Name: "receipt",
+				Name:    "receipt",
+				Indexes: receiptIndexes,
+			},
+			"idempotency": {
+				Name: "idempotency",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "Key"},
+					},
+				},
+			},
+			"customer": {
+				Name: "customer",
 				Indexes: map[string]*memdb.IndexSchema{
 					"id": {
 						Name:    "id",
@@ -27,6 +97,42 @@ func SetupDatabase() *ReceiptDatabase {
 					},
 				},
 			},
+			// ledger entries are read in order for each customer, so they are indexed by customer and sequence
+			"ledger": {
+				Name: "ledger",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "Id"},
+					},
+					"customer": {
+						Name:   "customer",
+						Unique: true,
+						Indexer: &memdb.CompoundIndex{
+							Indexes: []memdb.Indexer{
+								&memdb.StringFieldIndex{Field: "CustomerId"},
+								&memdb.UintFieldIndex{Field: "Sequence"},
+							},
+						},
+					},
+				},
+			},
+			// active holds are found by status so that expired holds can be released
+			"hold": {
+				Name: "hold",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "Id"},
+					},
+					"status": {
+						Name:    "status",
+						Indexer: &memdb.StringFieldIndex{Field: "Status"},
+					},
+				},
+			},
 		},
 	}
 
@@ -36,36 +142,333 @@ func SetupDatabase() *ReceiptDatabase {
 		log.Fatalf("Error while initializing database. %s", err)
 	}
 
+	tiers, err := NewTierPolicy(config.LoyaltyTiers, config.LoyaltyTierBasis, config.LoyaltyTierWindow)
+
+	if err != nil {
+		log.Fatalf("Error while configuring loyalty tiers. %s", err)
+	}
+
+	storage, err := NewReceiptStorage(config)
+
+	if err != nil {
+		log.Fatalf("Error while initializing database storage. %s", err)
+	}
+
 	db := &ReceiptDatabase{
-		MemDB: memdb,
+		MemDB:       memdb,
+		Storage:     storage,
+		Rules:       rules,
+		Expiration:  ExpirationPolicy{Months: config.PointsExpiryMonths, Warning: config.PointsExpiryWarning},
+		Tiers:       tiers,
+		sortIndexes: sortIndexes,
+		writeLock:   &sync.Mutex{},
+		stop:        make(chan struct{}),
+		workers:     &sync.WaitGroup{},
+	}
+
+	// Restore any previously stored records
+	if err := db.Restore(); err != nil {
+		log.Fatalf("Error while restoring database from storage. %s", err)
 	}
 
 	// Load some sample data to make it easier to test
 	db.LoadExampleData()
 
+	db.workers.Add(2)
+	go db.snapshotPeriodically(config.StorageSnapshotInterval)
+	go db.sweepPeriodically(config.SweepInterval)
+
 	return db
 }
 
+// Returned when inserting a receipt with the same content as an existing receipt
+type DuplicateReceiptError struct {
+	Id string
+}
+
+func (err *DuplicateReceiptError) Error() string {
+	return fmt.Sprintf("receipt is a duplicate of existing receipt %s", err.Id)
+}
+
+// Returned when inserting a receipt with the id of an existing receipt, which is never overwritten
+type ReceiptExistsError struct {
+	Id string
+}
+
+func (err *ReceiptExistsError) Error() string {
+	return fmt.Sprintf("a receipt already exists with id %s", err.Id)
+}
+
 // Insert a new receipt
 func (db ReceiptDatabase) InsertReceipt(receipt *Receipt) (*string, error) {
-	// ensure that the ID is set before database insertion
-	id := receipt.GetId()
+	rejected, err := db.InsertReceipts([]*Receipt{receipt})
+
+	if err != nil {
+		return nil, err
+	}
 
-	if receipt.Id == nil {
-		return nil, errors.New("unable to insert receipt with no Id field value set")
+	if rejected[0] != nil {
+		return nil, rejected[0]
 	}
 
+	id := receipt.GetId()
+
+	return &id, nil
+}
+
+// Insert several receipts in a single transaction. A receipt that is rejected (eg. a duplicate, or a receipt with the id of an
+// existing receipt) has its error returned at the same position in the rejected list and every other receipt is still inserted.
+// Any other error aborts the entire transaction.
+func (db ReceiptDatabase) InsertReceipts(receipts []*Receipt) ([]error, error) {
+	rejected := make([]error, len(receipts))
+	records := make([]StorageRecord, 0, len(receipts))
+
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
 	txn := db.MemDB.Txn(true)
 
-	if err := txn.Insert("receipt", receipt); err != nil {
+	for i, receipt := range receipts {
+		// ensure that the ID is set before database insertion
+		receipt.GetId()
+
+		if existing, err := txn.First("receipt", "id", receipt.GetId()); err != nil {
+			txn.Abort()
+
+			return nil, fmt.Errorf("error while querying database for receipt %s. %s", receipt.GetId(), err)
+		} else if existing != nil {
+			rejected[i] = &ReceiptExistsError{Id: receipt.GetId()}
+			continue
+		}
+
+		// duplicates are checked within the transaction, so a receipt repeated within the batch is also caught
+		if err := checkDuplicateReceipt(txn, receipt); err != nil {
+			var duplicate *DuplicateReceiptError
+
+			if !errors.As(err, &duplicate) {
+				txn.Abort()
+
+				return nil, err
+			}
+
+			rejected[i] = err
+			continue
+		}
+
+		// points are calculated once, when the receipt is inserted
+		if err := db.scoreReceipt(txn, receipt, db.Rules); err != nil {
+			txn.Abort()
+
+			return nil, err
+		}
+
+		// every new receipt starts out active, whatever was submitted. Existing receipts were rejected above, so the history of a
+		// voided or refunded receipt is never reset.
+		receipt.Refunds = nil
+		receipt.StatusHistory = nil
+		receipt.setStatus(ReceiptStatusActive, "", time.Now().UTC())
+
+		if err := receipt.fillParseCaches(); err != nil {
+			txn.Abort()
+
+			return nil, fmt.Errorf("unable to parse receipt %s. %s", receipt.GetId(), err)
+		}
+
+		if err := txn.Insert("receipt", receipt); err != nil {
+			txn.Abort()
+
+			return nil, fmt.Errorf("unable to insert receipt because of unknown error. %s", err)
+		}
+
+		records = append(records, StorageRecord{Type: StorageRecordReceipt, Receipt: receipt})
+
+		// the points are earned by the customer the receipt was submitted for
+		if receipt.CustomerId != "" {
+			record, err := appendLedgerEntry(txn, &LedgerEntry{
+				CustomerId: receipt.CustomerId,
+				Type:       LedgerEntryEarn,
+				Points:     *receipt.Points,
+				ReceiptId:  receipt.GetId(),
+			})
+
+			if err != nil {
+				txn.Abort()
+
+				return nil, err
+			}
+
+			records = append(records, record)
+		}
+	}
+
+	// persist the receipts before they become visible to readers
+	if err := db.Storage.Append(records...); err != nil {
 		txn.Abort()
 
-		return nil, fmt.Errorf("unable to insert receipt because of unknown error. %s", err)
+		return nil, fmt.Errorf("unable to persist receipts. %s", err)
 	}
 
 	txn.Commit()
 
-	return &id, nil
+	return rejected, nil
+}
+
+// Calculate the points for a receipt using a rule set and the loyalty tier of its customer, storing them on the receipt along
+// with the rules version. This must only be called before the receipt is inserted.
+func (db ReceiptDatabase) scoreReceipt(txn *memdb.Txn, receipt *Receipt, rules *RuleSet) error {
+	tier, err := db.Tiers.receiptTier(txn, receipt)
+
+	if err != nil {
+		return fmt.Errorf("error while evaluating loyalty tier for receipt %s. %s", receipt.GetId(), err)
+	}
+
+	receipt.Tier = tier
+
+	return receipt.rescore(rules)
+}
+
+// The points of a single receipt before and after it was re-scored
+type RescoreDiff struct {
+	Id           string `json:"id"`
+	OldPoints    *int   `json:"oldPoints"`
+	OldVersion   string `json:"oldRulesVersion"`
+	NewPoints    int    `json:"newPoints"`
+	NewVersion   string `json:"newRulesVersion"`
+	PointsChange int    `json:"pointsChange"`
+}
+
+// The outcome of re-scoring receipts under a rule set version
+type RescoreReport struct {
+	RulesVersion string        `json:"rulesVersion"`
+	DryRun       bool          `json:"dryRun"`
+	Rescored     int           `json:"rescored"`
+	Changed      int           `json:"changed"`
+	PointsChange int           `json:"pointsChange"`
+	Receipts     []RescoreDiff `json:"receipts"`
+}
+
+// Recalculate the points stored on receipts using the current rules. Only receipts scored by a different rules version are
+// recalculated, unless all is set.
+func (db ReceiptDatabase) RecomputePoints(all bool) (*RescoreReport, error) {
+	return db.RescoreReceipts(db.Rules, func(receipt *Receipt) bool {
+		return all || receipt.Points == nil || receipt.RulesVersion != db.Rules.Version
+	}, false)
+}
+
+// Re-score every matching receipt under a rule set, returning the old and new points of each receipt. Receipts keep the loyalty
+// tier they were awarded, and partially refunded receipts are capped like a refund. The new points are only stored when this
+// is not a dry run.
+func (db ReceiptDatabase) RescoreReceipts(rules *RuleSet, match func(receipt *Receipt) bool, dryRun bool) (*RescoreReport, error) {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	it, err := txn.Get("receipt", "purchase_datetime")
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying receipts to re-score. %s", err)
+	}
+
+	// collect the receipts first, since the index can't be modified while it is being iterated
+	matched := make([]*Receipt, 0)
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		// voided and refunded receipts no longer earn points, so they keep the points they were closed with
+		if receipt := raw.(*Receipt); !receipt.IsClosed() && match(receipt) {
+			matched = append(matched, receipt)
+		}
+	}
+
+	report := &RescoreReport{
+		RulesVersion: rules.Version,
+		DryRun:       dryRun,
+		Receipts:     make([]RescoreDiff, 0, len(matched)),
+	}
+
+	records := make([]StorageRecord, 0, len(matched))
+
+	for _, receipt := range matched {
+		// a re-scored copy replaces the original, with its own items since scoring caches parsed prices on them
+		updated := *receipt
+		updated.Items = slices.Clone(receipt.Items)
+
+		if err := updated.rescore(rules); err != nil {
+			return nil, err
+		}
+
+		diff := RescoreDiff{
+			Id:         updated.GetId(),
+			OldPoints:  receipt.Points,
+			OldVersion: receipt.RulesVersion,
+			NewPoints:  *updated.Points,
+			NewVersion: updated.RulesVersion,
+		}
+
+		if receipt.Points != nil {
+			diff.PointsChange = *updated.Points - *receipt.Points
+		}
+
+		report.Rescored++
+		report.PointsChange += diff.PointsChange
+		report.Receipts = append(report.Receipts, diff)
+
+		if receipt.Points == nil || diff.PointsChange != 0 {
+			report.Changed++
+		}
+
+		if dryRun {
+			continue
+		}
+
+		if err := txn.Insert("receipt", &updated); err != nil {
+			return nil, fmt.Errorf("unable to update points for receipt %s. %s", updated.GetId(), err)
+		}
+
+		records = append(records, StorageRecord{Type: StorageRecordReceipt, Receipt: &updated})
+
+		adjusted, err := adjustReceiptPoints(txn, &updated, diff.PointsChange)
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, adjusted...)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err := db.Storage.Append(records...); err != nil {
+		return nil, fmt.Errorf("unable to persist re-scored points. %s", err)
+	}
+
+	txn.Commit()
+
+	return report, nil
+}
+
+// Check for an existing receipt with the same content, returning a DuplicateReceiptError if one is found
+func checkDuplicateReceipt(txn *memdb.Txn, receipt *Receipt) error {
+	fingerprint, err := receipt.GetFingerprint()
+
+	if err != nil {
+		return fmt.Errorf("unable to fingerprint receipt. %s", err)
+	}
+
+	raw, err := txn.First("receipt", "fingerprint", fingerprint)
+
+	if err != nil {
+		return fmt.Errorf("error while querying database for duplicate receipts. %s", err)
+	}
+
+	if raw != nil && raw.(*Receipt).GetId() != receipt.GetId() {
+		return &DuplicateReceiptError{Id: raw.(*Receipt).GetId()}
+	}
+
+	return nil
 }
 
 // Get all receipts
@@ -81,16 +484,190 @@ func (db ReceiptDatabase) GetAllReceipts() ([]*Receipt, error) {
 		return nil, fmt.Errorf("error while querying receipts from database. %s", err)
 	}
 
-	// TODO: pagination, offsets, filtering, etc.
 	for raw := it.Next(); raw != nil; raw = it.Next() {
-		receipt := raw.(*Receipt)
-		receipts = append(receipts, receipt)
-		log.Printf("loaded receipt with id %s", receipt.GetId())
+		receipts = append(receipts, raw.(*Receipt))
 	}
 
 	return receipts, nil
 }
 
+// Get a single page of receipts. Receipts are read from the index matching the sort order, which is prefixed by retailer when
+// filtering by retailer, and the range filters on the sort field are used to bound the scan. Any remaining filters are then
+// applied to each receipt in the range.
+func (db ReceiptDatabase) QueryReceipts(query *ReceiptQuery) (*ReceiptPage, error) {
+	indexes, exists := receiptSortIndexes[query.SortBy]
+
+	if !exists {
+		return nil, fmt.Errorf("unable to sort receipts by %s", query.SortBy)
+	}
+
+	indexName := indexes[0]
+	prefix := []byte{}
+
+	if query.Retailer != "" {
+		indexName = indexes[1]
+		prefix = retailerKey(query.Retailer)
+	}
+
+	index := db.sortIndexes[indexName]
+
+	// find the range of keys that can match the filters
+	lower := prefix
+	upper := prefixEnd(prefix)
+
+	switch query.SortBy {
+	case SortByPurchaseDatetime:
+		if query.PurchaseDateFrom != "" {
+			lower = append(bytes.Clone(prefix), query.PurchaseDateFrom...)
+		}
+
+		if query.PurchaseDateTo != "" {
+			upper = prefixEnd(append(bytes.Clone(prefix), query.PurchaseDateTo...))
+		}
+	case SortByPoints:
+		if query.MinPoints != nil {
+			lower = append(bytes.Clone(prefix), pointsKey(*query.MinPoints)...)
+		}
+	case SortByTotal:
+		if query.MinTotal != nil {
+			lower = append(bytes.Clone(prefix), totalKey(*query.MinTotal)...)
+		}
+
+		if query.MaxTotal != nil {
+			upper = prefixEnd(append(bytes.Clone(prefix), totalKey(*query.MaxTotal)...))
+		}
+	}
+
+	var cursor []byte
+
+	if query.Cursor != "" {
+		key, err := decodeCursor(indexName, query.Cursor)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cursor = key
+	}
+
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	var it memdb.ResultIterator
+	var err error
+
+	if query.Descending {
+		start := []byte{0xff}
+
+		if upper != nil {
+			start = upper
+		}
+
+		if cursor != nil && bytes.Compare(cursor, start) < 0 {
+			start = cursor
+		}
+
+		it, err = txn.ReverseLowerBound("receipt", indexName, start)
+	} else {
+		start := lower
+
+		if cursor != nil && bytes.Compare(cursor, start) > 0 {
+			start = cursor
+		}
+
+		it, err = txn.LowerBound("receipt", indexName, start)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying receipts from database. %s", err)
+	}
+
+	page := &ReceiptPage{
+		Receipts: make([]*Receipt, 0),
+	}
+
+	var last []byte
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		receipt := raw.(*Receipt)
+
+		_, key, err := index.keyFor(receipt)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while reading receipt %s from index. %s", receipt.GetId(), err)
+		}
+
+		// stop once the scan leaves the range, and skip anything at or before the cursor
+		if query.Descending {
+			if bytes.Compare(key, lower) < 0 {
+				break
+			}
+
+			if (upper != nil && bytes.Compare(key, upper) >= 0) || (cursor != nil && bytes.Compare(key, cursor) >= 0) {
+				continue
+			}
+		} else {
+			if upper != nil && bytes.Compare(key, upper) >= 0 {
+				break
+			}
+
+			if cursor != nil && bytes.Compare(key, cursor) <= 0 {
+				continue
+			}
+		}
+
+		matches, err := db.matchesQuery(receipt, query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !matches {
+			continue
+		}
+
+		// one receipt past the limit means there is another page
+		if len(page.Receipts) == query.Limit {
+			page.NextCursor = encodeCursor(indexName, last)
+			break
+		}
+
+		page.Receipts = append(page.Receipts, receipt)
+		last = key
+	}
+
+	return page, nil
+}
+
+// Check a receipt against the query filters that are not covered by the index range
+func (db ReceiptDatabase) matchesQuery(receipt *Receipt, query *ReceiptQuery) (bool, error) {
+	if query.PurchaseDateFrom != "" && receipt.PurchaseDate < query.PurchaseDateFrom {
+		return false, nil
+	}
+
+	if query.PurchaseDateTo != "" && receipt.PurchaseDate > query.PurchaseDateTo {
+		return false, nil
+	}
+
+	if query.MinTotal != nil || query.MaxTotal != nil {
+		total, err := receipt.GetPurchaseTotal()
+
+		if err != nil {
+			return false, nil
+		}
+
+		if (query.MinTotal != nil && total < *query.MinTotal) || (query.MaxTotal != nil && total > *query.MaxTotal) {
+			return false, nil
+		}
+	}
+
+	if query.MinPoints != nil && (receipt.Points == nil || *receipt.Points < *query.MinPoints) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
 // Get a receipt by ID
 func (db ReceiptDatabase) GetReceiptById(id string) (*Receipt, error) {
 	txn := db.MemDB.Txn(false)
@@ -102,9 +679,217 @@ func (db ReceiptDatabase) GetReceiptById(id string) (*Receipt, error) {
 		return nil, fmt.Errorf("error while querying database for id %s. %s", id, err)
 	}
 
+	if raw == nil {
+		return nil, fmt.Errorf("no receipt exists with id %s", id)
+	}
+
 	return raw.(*Receipt), nil
 }
 
+// Rebuild the database from the records held in storage
+func (db ReceiptDatabase) Restore() error {
+	records, err := db.Storage.Load()
+
+	if err != nil {
+		return err
+	}
+
+	txn := db.MemDB.Txn(true)
+
+	for _, record := range records {
+		if err := db.applyStorageRecord(txn, record); err != nil {
+			txn.Abort()
+
+			return err
+		}
+	}
+
+	txn.Commit()
+
+	log.Printf("restored %d records from storage", len(records))
+
+	return nil
+}
+
+// Apply a single storage record to a write transaction
+func (db ReceiptDatabase) applyStorageRecord(txn *memdb.Txn, record StorageRecord) error {
+	switch record.Type {
+	case StorageRecordReceipt:
+		if record.Receipt == nil || record.Receipt.Id == nil {
+			return errors.New("receipt storage record has no receipt id")
+		}
+
+		// receipts stored before points were persisted are scored using the current rules
+		if record.Receipt.Points == nil {
+			if err := db.scoreReceipt(txn, record.Receipt, db.Rules); err != nil {
+				return err
+			}
+		}
+
+		if err := record.Receipt.fillParseCaches(); err != nil {
+			return fmt.Errorf("unable to parse receipt %s. %s", *record.Receipt.Id, err)
+		}
+
+		if err := txn.Insert("receipt", record.Receipt); err != nil {
+			return fmt.Errorf("unable to restore receipt %s. %s", *record.Receipt.Id, err)
+		}
+	case StorageRecordIdempotency:
+		if record.Idempotency == nil {
+			return errors.New("idempotency storage record has no idempotency key")
+		}
+
+		// expired records are no longer needed
+		if record.Idempotency.IsExpired(time.Now()) {
+			return nil
+		}
+
+		if err := txn.Insert("idempotency", record.Idempotency); err != nil {
+			return fmt.Errorf("unable to restore idempotency key %s. %s", record.Idempotency.Key, err)
+		}
+	case StorageRecordCustomer:
+		if record.Customer == nil || record.Customer.Id == "" {
+			return errors.New("customer storage record has no customer id")
+		}
+
+		if err := txn.Insert("customer", record.Customer); err != nil {
+			return fmt.Errorf("unable to restore customer %s. %s", record.Customer.Id, err)
+		}
+	case StorageRecordLedger:
+		if record.LedgerEntry == nil || record.LedgerEntry.Id == "" {
+			return errors.New("ledger storage record has no ledger entry id")
+		}
+
+		if err := txn.Insert("ledger", record.LedgerEntry); err != nil {
+			return fmt.Errorf("unable to restore ledger entry %s. %s", record.LedgerEntry.Id, err)
+		}
+	case StorageRecordHold:
+		if record.Hold == nil || record.Hold.Id == "" {
+			return errors.New("hold storage record has no hold id")
+		}
+
+		if err := txn.Insert("hold", record.Hold); err != nil {
+			return fmt.Errorf("unable to restore hold %s. %s", record.Hold.Id, err)
+		}
+	default:
+		return fmt.Errorf("unknown storage record type %s", record.Type)
+	}
+
+	return nil
+}
+
+// Write a snapshot of the entire database to storage, replacing all previously stored records
+func (db ReceiptDatabase) Snapshot() error {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	return db.snapshot()
+}
+
+// Write a snapshot of the entire database to storage. The write lock must be held by the caller.
+func (db ReceiptDatabase) snapshot() error {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	records := make([]StorageRecord, 0)
+
+	it, err := txn.Get("receipt", "id")
+
+	if err != nil {
+		return fmt.Errorf("error while querying receipts for snapshot. %s", err)
+	}
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		records = append(records, StorageRecord{Type: StorageRecordReceipt, Receipt: raw.(*Receipt)})
+	}
+
+	it, err = txn.Get("customer", "id")
+
+	if err != nil {
+		return fmt.Errorf("error while querying customers for snapshot. %s", err)
+	}
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		records = append(records, StorageRecord{Type: StorageRecordCustomer, Customer: raw.(*Customer)})
+	}
+
+	it, err = txn.Get("ledger", "id")
+
+	if err != nil {
+		return fmt.Errorf("error while querying ledger entries for snapshot. %s", err)
+	}
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		records = append(records, StorageRecord{Type: StorageRecordLedger, LedgerEntry: raw.(*LedgerEntry)})
+	}
+
+	it, err = txn.Get("hold", "id")
+
+	if err != nil {
+		return fmt.Errorf("error while querying holds for snapshot. %s", err)
+	}
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		records = append(records, StorageRecord{Type: StorageRecordHold, Hold: raw.(*Hold)})
+	}
+
+	it, err = txn.Get("idempotency", "id")
+
+	if err != nil {
+		return fmt.Errorf("error while querying idempotency keys for snapshot. %s", err)
+	}
+
+	// pending and expired idempotency keys are left out of the snapshot
+	now := time.Now()
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		if record := raw.(*IdempotencyRecord); !record.Pending && !record.IsExpired(now) {
+			records = append(records, StorageRecord{Type: StorageRecordIdempotency, Idempotency: record})
+		}
+	}
+
+	return db.Storage.Snapshot(records)
+}
+
+// Take a snapshot on a fixed interval until the database is closed
+func (db ReceiptDatabase) snapshotPeriodically(interval time.Duration) {
+	defer db.workers.Done()
+
+	if interval <= 0 {
+		<-db.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.Snapshot(); err != nil {
+				log.Printf("error while taking database snapshot. %s", err)
+			}
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// Stop taking periodic snapshots, write a final snapshot and flush all pending writes to storage. Writes already in progress
+// complete first, while any later write waits forever, so storage is never written to after it is closed.
+func (db ReceiptDatabase) Close() error {
+	close(db.stop)
+	db.workers.Wait()
+
+	// the write lock is never released
+	db.writeLock.Lock()
+
+	if err := db.snapshot(); err != nil {
+		return err
+	}
+
+	return db.Storage.Close()
+}
+
 // Load example data into the database
 func (db ReceiptDatabase) LoadExampleData() {
 	txn := db.MemDB.Txn(true)
@@ -204,6 +989,28 @@ func (db ReceiptDatabase) LoadExampleData() {
 
 	for _, receipt := range receipts {
 		id := receipt.GetId()
+
+		// an example receipt restored from storage keeps the points stored with it
+		if existing, err := txn.First("receipt", "id", id); err == nil && existing != nil {
+			continue
+		}
+
+		// a receipt with the same content may already have been submitted and restored from storage
+		if err := checkDuplicateReceipt(txn, receipt); err != nil {
+			log.Printf("skipping example receipt with id %s. %s", id, err)
+			continue
+		}
+
+		if err := db.scoreReceipt(txn, receipt, db.Rules); err != nil {
+			log.Fatalf("Error while scoring example database data. %s", err)
+		}
+
+		receipt.setStatus(ReceiptStatusActive, "", time.Now().UTC())
+
+		if err := receipt.fillParseCaches(); err != nil {
+			log.Fatalf("Error while parsing example database data. %s", err)
+		}
+
 		log.Printf("inserting example receipt with id %s", id)
 		if err := txn.Insert("receipt", receipt); err != nil {
 			log.Fatalf("Error while inserting example database data. %s", err)
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func setupTestDatabase(t *testing.T) *ReceiptDatabase {
+	db := SetupDatabase(&Config{StorageBackend: StorageBackendMemory}, DefaultRuleSet())
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return db
+}
+
+func receiptIds(page *ReceiptPage) []string {
+	ids := make([]string, 0)
+
+	for _, receipt := range page.Receipts {
+		ids = append(ids, receipt.GetId())
+	}
+
+	return ids
+}
+
+func expectReceiptIds(t *testing.T, page *ReceiptPage, expected ...string) {
+	t.Helper()
+
+	actual := receiptIds(page)
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected receipts %v, but received %v instead", expected, actual)
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected receipts %v, but received %v instead", expected, actual)
+		}
+	}
+}
+
+// The example data contains four receipts
+//
+//	A Walgreens          2022-01-02 08:13   2.65   15 points
+//	B Target             2022-01-02 13:13   1.25   31 points
+//	C Target             2022-01-01 13:01  35.35   28 points
+//	D M&M Corner Market  2022-03-20 14:33   9.00  109 points
+const (
+	exampleIdA = "ae6ad71c-e978-4e93-a8a9-5909dc3d4422"
+	exampleIdB = "98557e85-1663-4d3b-adff-bbba1d002c4e"
+	exampleIdC = "392abbcf-4783-49f4-901c-ae0c708783df"
+	exampleIdD = "cbf19128-6408-4b47-9d20-08c2e84a9341"
+)
+
+func TestQueryReceiptsPagination(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	first, err := db.QueryReceipts(&ReceiptQuery{Limit: 2, SortBy: SortByPoints})
+
+	if err != nil {
+		t.Fatalf("unexpected error while querying receipts. %s", err)
+	}
+
+	expectReceiptIds(t, first, exampleIdA, exampleIdC)
+
+	if first.NextCursor == "" {
+		t.Fatalf("expected a cursor for the next page, but received none")
+	}
+
+	second, err := db.QueryReceipts(&ReceiptQuery{Limit: 2, SortBy: SortByPoints, Cursor: first.NextCursor})
+
+	if err != nil {
+		t.Fatalf("unexpected error while querying receipts. %s", err)
+	}
+
+	expectReceiptIds(t, second, exampleIdB, exampleIdD)
+
+	if second.NextCursor != "" {
+		t.Errorf("expected no cursor after the last page, but received %s", second.NextCursor)
+	}
+
+	if _, err := db.QueryReceipts(&ReceiptQuery{Limit: 2, SortBy: SortByPurchaseDatetime, Cursor: first.NextCursor}); err == nil {
+		t.Errorf("expected error while using a cursor with a different sort order, but received nil")
+	}
+}
+
+func TestQueryReceiptsDescending(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	first, err := db.QueryReceipts(&ReceiptQuery{Limit: 3, SortBy: SortByPurchaseDatetime, Descending: true})
+
+	if err != nil {
+		t.Fatalf("unexpected error while querying receipts. %s", err)
+	}
+
+	expectReceiptIds(t, first, exampleIdD, exampleIdB, exampleIdA)
+
+	second, err := db.QueryReceipts(&ReceiptQuery{Limit: 3, SortBy: SortByPurchaseDatetime, Descending: true, Cursor: first.NextCursor})
+
+	if err != nil {
+		t.Fatalf("unexpected error while querying receipts. %s", err)
+	}
+
+	expectReceiptIds(t, second, exampleIdC)
+}
+
+func TestQueryReceiptsFilters(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	minTotal := Money(900)
+	minPoints := 30
+	lowTotal, highTotal := Money(200), Money(900)
+
+	cases := []struct {
+		query    ReceiptQuery
+		expected []string
+	}{
+		{
+			query:    ReceiptQuery{SortBy: SortByPurchaseDatetime, Retailer: " target "},
+			expected: []string{exampleIdC, exampleIdB},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByPoints, Retailer: "Target", Descending: true},
+			expected: []string{exampleIdB, exampleIdC},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByPurchaseDatetime, PurchaseDateFrom: "2022-01-02", PurchaseDateTo: "2022-01-02"},
+			expected: []string{exampleIdA, exampleIdB},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByPoints, PurchaseDateTo: "2022-01-02", Descending: true},
+			expected: []string{exampleIdB, exampleIdC, exampleIdA},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByPurchaseDatetime, MinTotal: &minTotal},
+			expected: []string{exampleIdC, exampleIdD},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByTotal, MinTotal: &lowTotal, MaxTotal: &highTotal},
+			expected: []string{exampleIdA, exampleIdD},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByTotal, MaxTotal: &highTotal, Descending: true},
+			expected: []string{exampleIdD, exampleIdA, exampleIdB},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByTotal, Retailer: "target", MinTotal: &lowTotal},
+			expected: []string{exampleIdC},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByPoints, MinPoints: &minPoints},
+			expected: []string{exampleIdB, exampleIdD},
+		},
+		{
+			query:    ReceiptQuery{SortBy: SortByPurchaseDatetime, MinPoints: &minPoints, Retailer: "Target"},
+			expected: []string{exampleIdB},
+		},
+	}
+
+	for _, test := range cases {
+		test.query.Limit = DefaultQueryLimit
+
+		page, err := db.QueryReceipts(&test.query)
+
+		if err != nil {
+			t.Fatalf("unexpected error while querying receipts. %s", err)
+		}
+
+		expectReceiptIds(t, page, test.expected...)
+	}
+}
+
+func TestInsertDuplicateReceipt(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	// the same content as example receipt A, with different casing, whitespace and item order
+	a := &Receipt{
+		Retailer:      " walgreens",
+		PurchaseDate:  "2022-01-02",
+		PurchaseTime:  "08:13",
+		PurchaseTotal: "2.65",
+		Items: []ReceiptItem{
+			{
+				ShortDescription: "DASANI",
+				Price:            "1.40",
+			},
+			{
+				ShortDescription: "Pepsi - 12-oz ",
+				Price:            "1.25",
+			},
+		},
+	}
+
+	_, err := db.InsertReceipt(a)
+
+	duplicate, ok := err.(*DuplicateReceiptError)
+
+	if !ok || duplicate.Id != exampleIdA {
+		t.Fatalf("expected duplicate of receipt %s, but received %v instead", exampleIdA, err)
+	}
+
+	// a different purchase time is a different receipt
+	a.PurchaseTime = "08:14"
+
+	if _, err := db.InsertReceipt(a); err != nil {
+		t.Errorf("unexpected error while inserting receipt. %s", err)
+	}
+}
+
+func TestIdempotencyKeyLifecycle(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	reserved, ok, err := db.ReserveIdempotencyKey("key", "hash", time.Now().Add(time.Hour))
+
+	if err != nil || !ok || !reserved.Pending {
+		t.Fatalf("expected a new pending reservation, but received %v %t %v", reserved, ok, err)
+	}
+
+	// a retry while the original request is still being processed sees the pending reservation
+	if existing, ok, _ := db.ReserveIdempotencyKey("key", "hash", time.Now().Add(time.Hour)); ok || !existing.Pending {
+		t.Errorf("expected the existing pending reservation, but received %v %t", existing, ok)
+	}
+
+	if err := db.CompleteIdempotencyKey(reserved, 200, []byte(`{"id":"abc"}`)); err != nil {
+		t.Fatalf("unexpected error while completing idempotency key. %s", err)
+	}
+
+	existing, ok, _ := db.ReserveIdempotencyKey("key", "other", time.Now().Add(time.Hour))
+
+	if ok || existing.Pending || existing.StatusCode != 200 || existing.RequestHash != "hash" || string(existing.Response) != `{"id":"abc"}` {
+		t.Errorf("expected the completed record, but received %v %t", existing, ok)
+	}
+
+	// an expired key can be reused
+	expired, _, _ := db.ReserveIdempotencyKey("expired", "hash", time.Now().Add(-time.Second))
+
+	if err := db.CompleteIdempotencyKey(expired, 200, []byte(`{}`)); err != nil {
+		t.Fatalf("unexpected error while completing idempotency key. %s", err)
+	}
+
+	if _, ok, _ := db.ReserveIdempotencyKey("expired", "other", time.Now().Add(time.Hour)); !ok {
+		t.Errorf("expected an expired idempotency key to be reserved again")
+	}
+}
+
+func TestPurgeExpiredIdempotencyKeys(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	live, _, _ := db.ReserveIdempotencyKey("live", "hash", time.Now().Add(time.Hour))
+	expired, _, _ := db.ReserveIdempotencyKey("expired", "hash", time.Now().Add(-time.Second))
+
+	db.CompleteIdempotencyKey(live, 200, []byte(`{}`))
+	db.CompleteIdempotencyKey(expired, 200, []byte(`{}`))
+
+	// expired keys are deleted even if they are never reused
+	if purged, err := db.PurgeExpiredIdempotencyKeys(time.Now()); err != nil || purged != 1 {
+		t.Fatalf("expected 1 expired idempotency key to be purged, but received %d. %v", purged, err)
+	}
+
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	if raw, _ := txn.First("idempotency", "id", "expired"); raw != nil {
+		t.Errorf("expected the expired idempotency key to be deleted, but received %v", raw)
+	}
+
+	if raw, _ := txn.First("idempotency", "id", "live"); raw == nil {
+		t.Error("expected the unexpired idempotency key to be kept")
+	}
+}
+
+func TestRecomputePoints(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	// points are stored when a receipt is inserted
+	if d, _ := db.GetReceiptById(exampleIdD); d.Points == nil || *d.Points != 109 {
+		t.Fatalf("expected 109 stored points, but received %v", d.Points)
+	}
+
+	rules, err := NewRuleSet([]string{"retailer-name"})
+
+	if err != nil {
+		t.Fatalf("unexpected error while building rule set. %s", err)
+	}
+
+	rules.Version = "2"
+	db.Rules = rules
+
+	report, err := db.RecomputePoints(false)
+
+	if err != nil {
+		t.Fatalf("unexpected error while recomputing points. %s", err)
+	}
+
+	if report.Rescored != 4 || report.Changed != 4 || report.RulesVersion != "2" {
+		t.Errorf("expected 4 receipts to be recomputed and changed, but received %v", report)
+	}
+
+	if d, _ := db.GetReceiptById(exampleIdD); *d.Points != 14 || d.RulesVersion != "2" {
+		t.Errorf("expected 14 points from rules version 2, but received %d from %s", *d.Points, d.RulesVersion)
+	}
+
+	// the points index is updated along with the receipts
+	page, err := db.QueryReceipts(&ReceiptQuery{Limit: DefaultQueryLimit, SortBy: SortByPoints, Descending: true})
+
+	if err != nil {
+		t.Fatalf("unexpected error while querying receipts. %s", err)
+	}
+
+	expectReceiptIds(t, page, exampleIdD, exampleIdA, exampleIdB, exampleIdC)
+
+	// receipts already scored by the current rules are skipped
+	if report, _ := db.RecomputePoints(false); report.Rescored != 0 {
+		t.Errorf("expected no receipts to be recomputed, but received %v", report)
+	}
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-memdb"
+)
+
+// When the points earned by a receipt expire, measured from the purchase date & time of the receipt in the timezone of the store
+type ExpirationPolicy struct {
+	// the number of months after the purchase that points expire, or 0 when points never expire
+	Months int
+
+	// how long before they expire points are reported as expiring soon
+	Warning time.Duration
+}
+
+// Spendable points earned by a single receipt that expire at the same time
+type ExpiringPoints struct {
+	ReceiptId string    `json:"receiptId"`
+	Points    int       `json:"points"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Get the time the points earned by a receipt expire, or nil if points never expire
+func (policy ExpirationPolicy) ExpiresAt(receipt *Receipt) (*time.Time, error) {
+	if policy.Months <= 0 {
+		return nil, nil
+	}
+
+	purchased, err := receipt.GetPurchaseDatetime()
+
+	if err != nil {
+		return nil, err
+	}
+
+	expires := purchased.AddDate(0, policy.Months, 0)
+
+	return &expires, nil
+}
+
+// Get the spendable points of a customer that expire by a given time, soonest first. Held points only expire once they are
+// released. No more than the balance of the customer is reported, since a reversal can leave the balance lower than the points
+// remaining on the receipts of the customer.
+func expiringPoints(txn *memdb.Txn, customerId string, policy ExpirationPolicy, until time.Time) ([]ExpiringPoints, error) {
+	expiring := make([]ExpiringPoints, 0)
+
+	if policy.Months <= 0 {
+		return expiring, nil
+	}
+
+	last, err := lastLedgerEntry(txn, customerId)
+
+	if err != nil || last == nil {
+		return expiring, err
+	}
+
+	lots, err := customerLots(txn, customerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lot := range lots {
+		if lot.Available <= 0 {
+			continue
+		}
+
+		raw, err := txn.First("receipt", "id", lot.ReceiptId)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while querying database for receipt %s. %s", lot.ReceiptId, err)
+		}
+
+		if raw == nil {
+			continue
+		}
+
+		expiresAt, err := policy.ExpiresAt(raw.(*Receipt))
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse purchase date & time of receipt %s. %s", lot.ReceiptId, err)
+		}
+
+		if expiresAt.After(until) {
+			continue
+		}
+
+		expiring = append(expiring, ExpiringPoints{ReceiptId: lot.ReceiptId, Points: lot.Available, ExpiresAt: expiresAt.UTC()})
+	}
+
+	sort.SliceStable(expiring, func(i, j int) bool {
+		return expiring[i].ExpiresAt.Before(expiring[j].ExpiresAt)
+	})
+
+	balance := last.Balance
+	limited := make([]ExpiringPoints, 0, len(expiring))
+
+	for _, points := range expiring {
+		if points.Points = min(points.Points, balance); points.Points <= 0 {
+			break
+		}
+
+		balance -= points.Points
+		limited = append(limited, points)
+	}
+
+	return limited, nil
+}
+
+// Sort the lots of a customer so that the points that expire soonest come first, keeping the credit order of lots that expire
+// at the same time. Lots without a receipt never expire, so they come last.
+func sortLotsByExpiry(txn *memdb.Txn, lots []*ledgerLot, policy ExpirationPolicy) error {
+	if policy.Months <= 0 {
+		return nil
+	}
+
+	expires := make(map[*ledgerLot]*time.Time, len(lots))
+
+	for _, lot := range lots {
+		raw, err := txn.First("receipt", "id", lot.ReceiptId)
+
+		if err != nil {
+			return fmt.Errorf("error while querying database for receipt %s. %s", lot.ReceiptId, err)
+		}
+
+		if raw == nil {
+			continue
+		}
+
+		if expires[lot], err = policy.ExpiresAt(raw.(*Receipt)); err != nil {
+			return fmt.Errorf("unable to parse purchase date & time of receipt %s. %s", lot.ReceiptId, err)
+		}
+	}
+
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := expires[lots[i]], expires[lots[j]]
+
+		return a != nil && (b == nil || a.Before(*b))
+	})
+
+	return nil
+}
+
+// Expire the points of a customer that were not spent in time, writing a ledger entry for each receipt
+func expirePoints(txn *memdb.Txn, customerId string, policy ExpirationPolicy, now time.Time) ([]StorageRecord, error) {
+	expiring, err := expiringPoints(txn, customerId, policy, now)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]StorageRecord, 0, len(expiring))
+
+	for _, points := range expiring {
+		record, err := appendLedgerEntry(txn, &LedgerEntry{
+			CustomerId: customerId,
+			Type:       LedgerEntryExpire,
+			Points:     -points.Points,
+			ReceiptId:  points.ReceiptId,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// Expire the points of every customer that were not spent in time, returning the number of points expired
+func (db ReceiptDatabase) ExpirePoints(now time.Time) (int, error) {
+	if db.Expiration.Months <= 0 {
+		return 0, nil
+	}
+
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	it, err := txn.Get("customer", "id")
+
+	if err != nil {
+		return 0, fmt.Errorf("error while querying customers. %s", err)
+	}
+
+	// collect the customers first, since the ledger can't be modified while the customers are being iterated
+	customers := make([]string, 0)
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		customers = append(customers, raw.(*Customer).Id)
+	}
+
+	records := make([]StorageRecord, 0)
+	expired := 0
+
+	for _, customerId := range customers {
+		expiredRecords, err := expirePoints(txn, customerId, db.Expiration, now)
+
+		if err != nil {
+			return 0, err
+		}
+
+		for _, record := range expiredRecords {
+			expired -= record.LedgerEntry.Points
+		}
+
+		records = append(records, expiredRecords...)
+	}
+
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if err := db.Storage.Append(records...); err != nil {
+		return 0, fmt.Errorf("unable to persist expired points. %s", err)
+	}
+
+	txn.Commit()
+
+	return expired, nil
+}
+
+// Get the spendable points of a customer that expire within the warning period of the expiration policy, soonest first
+func (db ReceiptDatabase) GetExpiringPoints(customerId string, now time.Time) ([]ExpiringPoints, error) {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	return expiringPoints(txn, customerId, db.Expiration, now.Add(db.Expiration.Warning))
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpirationPolicy(t *testing.T) {
+	receipt := &Receipt{PurchaseDate: "2022-01-02", PurchaseTime: "13:13", Timezone: "America/Chicago"}
+
+	if expiresAt, err := (ExpirationPolicy{}).ExpiresAt(receipt); err != nil || expiresAt != nil {
+		t.Errorf("expected points to never expire without a policy, but received %v. %v", expiresAt, err)
+	}
+
+	expiresAt, err := ExpirationPolicy{Months: 12}.ExpiresAt(receipt)
+
+	if err != nil {
+		t.Fatalf("unexpected error while calculating expiry. %s", err)
+	}
+
+	if expected := time.Date(2023, 1, 2, 19, 13, 0, 0, time.UTC); !expiresAt.Equal(expected) {
+		t.Errorf("expected points to expire at %s, but received %s", expected, expiresAt)
+	}
+}
+
+func TestExpirePoints(t *testing.T) {
+	db := setupTestDatabase(t)
+	db.Expiration = ExpirationPolicy{Months: 120, Warning: 30 * 24 * time.Hour}
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	// each receipt earns 31 points, which expire at 2032-01-02
+	first := insertCustomerReceipt(t, db, customer.Id, "09:13")
+	insertCustomerReceipt(t, db, customer.Id, "13:14")
+
+	db.RedeemPoints(customer.Id, 40)
+
+	if expiring, _ := db.GetExpiringPoints(customer.Id, time.Date(2031, 11, 1, 0, 0, 0, 0, time.UTC)); len(expiring) != 0 {
+		t.Errorf("expected no points to expire soon, but received %v", expiring)
+	}
+
+	// the points left on the first receipt were spent, so only the second receipt has points to expire
+	expiring, err := db.GetExpiringPoints(customer.Id, time.Date(2031, 12, 10, 0, 0, 0, 0, time.UTC))
+
+	if err != nil {
+		t.Fatalf("unexpected error while querying expiring points. %s", err)
+	}
+
+	if len(expiring) != 1 || expiring[0].Points != 22 || expiring[0].ReceiptId == first.GetId() {
+		t.Errorf("expected 22 points of the second receipt to expire soon, but received %v", expiring)
+	}
+
+	if expired, err := db.ExpirePoints(time.Date(2032, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil || expired != 0 {
+		t.Errorf("expected no points to expire yet, but received %d. %v", expired, err)
+	}
+
+	if expired, err := db.ExpirePoints(time.Date(2032, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil || expired != 22 {
+		t.Errorf("expected 22 points to expire, but received %d. %v", expired, err)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != 0 {
+		t.Errorf("expected a balance of 0, but received %d", balance)
+	}
+
+	page, _ := db.GetLedger(customer.Id, "", DefaultQueryLimit)
+
+	if last := page.Entries[len(page.Entries)-1]; last.Type != LedgerEntryExpire || last.Points != -22 {
+		t.Errorf("expected an expire entry of 22 points, but received %v", last)
+	}
+
+	// points are only expired once
+	if expired, _ := db.ExpirePoints(time.Date(2032, 3, 1, 0, 0, 0, 0, time.UTC)); expired != 0 {
+		t.Errorf("expected no more points to expire, but received %d", expired)
+	}
+
+	// reversing the second receipt only takes back the points that were spent, since the rest already expired
+	second := page.Entries[len(page.Entries)-1].ReceiptId
+
+	if entry, err := db.ReverseReceiptPoints(second); err != nil || entry.Points != -9 {
+		t.Errorf("expected a reversal of 9 points, but received %v. %v", entry, err)
+	}
+}
+
+func TestRedeemSoonestExpiringPoints(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	// the later purchase is credited first, but the points of the earlier purchase expire first
+	later := insertCustomerReceipt(t, db, customer.Id, "13:14")
+	earlier := insertCustomerReceipt(t, db, customer.Id, "09:13")
+
+	// without an expiration policy points are spent in the order they were credited
+	if redemption, _ := db.RedeemPoints(customer.Id, 1); redemption.Entries[0].ReceiptId != later.GetId() {
+		t.Errorf("expected the points of the later purchase to be spent, but received %v", redemption.Entries)
+	}
+
+	db.Expiration = ExpirationPolicy{Months: 120}
+
+	redemption, err := db.RedeemPoints(customer.Id, 31)
+
+	if err != nil {
+		t.Fatalf("unexpected error while redeeming points. %s", err)
+	}
+
+	if len(redemption.Entries) != 1 || redemption.Entries[0].ReceiptId != earlier.GetId() {
+		t.Errorf("expected the points of the earlier purchase to be spent, but received %v", redemption.Entries)
+	}
+}
+
+func TestExpirePointsAfterReversal(t *testing.T) {
+	db := setupTestDatabase(t)
+	db.Expiration = ExpirationPolicy{Months: 120}
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	first := insertCustomerReceipt(t, db, customer.Id, "13:14")
+	insertCustomerReceipt(t, db, customer.Id, "09:13")
+
+	// the reversal leaves a balance of -9, even though 22 points remain on the second receipt
+	db.RedeemPoints(customer.Id, 40)
+	db.ReverseReceiptPoints(first.GetId())
+
+	if expired, err := db.ExpirePoints(time.Date(2032, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil || expired != 0 {
+		t.Errorf("expected no points to expire below a zero balance, but received %d. %v", expired, err)
+	}
+}
+
+func TestRefundAfterPointsExpire(t *testing.T) {
+	db := setupTestDatabase(t)
+	db.Expiration = ExpirationPolicy{Months: 120}
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	// the 109 points of the receipt expire at 2032-03-20
+	receipt := insertCustomerGatoradeReceipt(t, db, customer.Id)
+
+	if expired, err := db.ExpirePoints(time.Date(2032, 4, 1, 0, 0, 0, 0, time.UTC)); err != nil || expired != 109 {
+		t.Fatalf("expected 109 points to expire, but received %d. %v", expired, err)
+	}
+
+	// the refund lowers the points of the receipt, but they already expired, so none are taken back again
+	if _, refund, err := db.RefundReceipt(receipt.GetId(), []int{3}, "", DefaultRuleSet()); err != nil || refund.PointsChange != -55 {
+		t.Fatalf("expected the refund to lower the points by 55, but received %v. %v", refund, err)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != 0 {
+		t.Errorf("expected a balance of 0, but received %d", balance)
+	}
+
+	if page, _ := db.GetLedger(customer.Id, "", DefaultQueryLimit); len(page.Entries) != 2 {
+		t.Errorf("expected no adjustment after the points expired, but received %v", page.Entries)
+	}
+}
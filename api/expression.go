@@ -0,0 +1,974 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Limits that keep a badly written expression from using too much time or memory while a request is scored
+const (
+	// the longest expression that can be compiled
+	MaxExpressionLength = 4096
+
+	// the deepest nesting of operators, calls and lists that can be compiled
+	MaxExpressionDepth = 64
+
+	// the most operations that can be performed while evaluating an expression once, unless the expression sets its own limit
+	ExpressionStepLimit = 10000
+)
+
+// Days of the week, as returned by the weekday variable. Each day may also be written in an expression without quotes.
+var expressionWeekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// The variables available to expressions evaluated for a receipt
+var receiptExpressionVariables = []string{
+	"retailer", "total", "itemCount", "purchaseDate", "purchaseTime", "year", "month", "day", "weekday", "hour", "minute",
+}
+
+// The additional variables available to expressions evaluated for each item
+var itemExpressionVariables = []string{"description", "price", "index", "length"}
+
+// A compiled expression, written in a small language over the receipt and item fields. Values are numbers (exact decimals),
+// strings, booleans and lists, eg. retailer matches "(?i)target" && total >= 20 && weekday in [SAT, SUN]
+//
+// Expressions can only read the variables they are given and call the built-in functions, so evaluating an expression has no
+// side effects, and every evaluation is limited to a fixed number of steps.
+type Expression struct {
+	Source    string
+	StepLimit int
+
+	root expressionNode
+}
+
+// A node in the syntax tree of an expression
+type expressionNode interface {
+	eval(evaluator *expressionEvaluator) (any, error)
+}
+
+// Compile an expression that may only refer to the given variables
+func CompileExpression(source string, variables []string) (*Expression, error) {
+	if len(source) > MaxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxExpressionLength)
+	}
+
+	tokens, err := lexExpression(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &expressionParser{
+		tokens:    tokens,
+		variables: make(map[string]bool),
+	}
+
+	for _, variable := range variables {
+		parser.variables[variable] = true
+	}
+
+	root, err := parser.parseExpression(0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %s at offset %d", token, token.offset)
+	}
+
+	return &Expression{Source: source, StepLimit: ExpressionStepLimit, root: root}, nil
+}
+
+// Evaluate the expression with the given variable values
+func (expression *Expression) Evaluate(variables map[string]any) (any, error) {
+	evaluator := &expressionEvaluator{variables: variables, limit: expression.StepLimit}
+
+	value, err := expression.root.eval(evaluator)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while evaluating expression %q. %s", expression.Source, err)
+	}
+
+	return value, nil
+}
+
+// Evaluate an expression that must result in a boolean
+func (expression *Expression) EvaluateBool(variables map[string]any) (bool, error) {
+	value, err := expression.Evaluate(variables)
+
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := value.(bool)
+
+	if !ok {
+		return false, fmt.Errorf("expression %q must result in a boolean, but resulted in a %s", expression.Source, typeName(value))
+	}
+
+	return result, nil
+}
+
+// Evaluate an expression that must result in a number
+func (expression *Expression) EvaluateNumber(variables map[string]any) (*big.Rat, error) {
+	value, err := expression.Evaluate(variables)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := value.(*big.Rat)
+
+	if !ok {
+		return nil, fmt.Errorf("expression %q must result in a number, but resulted in a %s", expression.Source, typeName(value))
+	}
+
+	return result, nil
+}
+
+// Get the values of the receipt variables
+func receiptExpressionValues(receipt *Receipt) (map[string]any, error) {
+	total, err := receipt.GetPurchaseTotal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	purchaseDatetime, err := receipt.GetPurchaseDatetime()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse time. %s", err)
+	}
+
+	return map[string]any{
+		"retailer":     strings.TrimSpace(receipt.Retailer),
+		"total":        big.NewRat(total.Cents(), 100),
+		"itemCount":    big.NewRat(int64(len(receipt.Items)), 1),
+		"purchaseDate": receipt.PurchaseDate,
+		"purchaseTime": receipt.PurchaseTime,
+		"year":         big.NewRat(int64(purchaseDatetime.Year()), 1),
+		"month":        big.NewRat(int64(purchaseDatetime.Month()), 1),
+		"day":          big.NewRat(int64(purchaseDatetime.Day()), 1),
+		"weekday":      expressionWeekdays[purchaseDatetime.Weekday()],
+		"hour":         big.NewRat(int64(purchaseDatetime.Hour()), 1),
+		"minute":       big.NewRat(int64(purchaseDatetime.Minute()), 1),
+	}, nil
+}
+
+// Get the values of the receipt variables along with the variables of a single item
+func itemExpressionValues(receipt map[string]any, index int, item *ReceiptItem) (map[string]any, error) {
+	price, err := item.GetPrice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]any, len(receipt)+len(itemExpressionVariables))
+
+	for name, value := range receipt {
+		values[name] = value
+	}
+
+	description := strings.TrimSpace(item.ShortDescription)
+
+	values["description"] = description
+	values["price"] = big.NewRat(price.Cents(), 100)
+	values["index"] = big.NewRat(int64(index), 1)
+	values["length"] = big.NewRat(int64(len(description)), 1)
+
+	return values, nil
+}
+
+// Lexer
+
+const (
+	tokenEnd = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type expressionToken struct {
+	kind   int
+	text   string
+	value  any
+	offset int
+}
+
+func (token expressionToken) String() string {
+	if token.kind == tokenEnd {
+		return "end of expression"
+	}
+
+	return fmt.Sprintf("%q", token.text)
+}
+
+var expressionOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "!", "(", ")", "[", "]", ","}
+
+func lexExpression(source string) ([]expressionToken, error) {
+	tokens := make([]expressionToken, 0)
+
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.') {
+				i++
+			}
+
+			value, ok := new(big.Rat).SetString(source[start:i])
+
+			if !ok || strings.HasSuffix(source[start:i], ".") {
+				return nil, fmt.Errorf("invalid number %q at offset %d", source[start:i], start)
+			}
+
+			tokens = append(tokens, expressionToken{kind: tokenNumber, text: source[start:i], value: value, offset: start})
+		case c == '"':
+			start := i
+			i++
+
+			for i < len(source) && source[i] != '"' {
+				if source[i] == '\\' {
+					i++
+				}
+
+				i++
+			}
+
+			if i >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", start)
+			}
+
+			i++
+
+			value, err := strconv.Unquote(source[start:i])
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d. %s", start, err)
+			}
+
+			tokens = append(tokens, expressionToken{kind: tokenString, text: source[start:i], value: value, offset: start})
+		case c == '_' || c < unicode.MaxASCII && unicode.IsLetter(c):
+			start := i
+
+			for i < len(source) && (source[i] == '_' || source[i] < unicode.MaxASCII && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])))) {
+				i++
+			}
+
+			tokens = append(tokens, expressionToken{kind: tokenIdent, text: source[start:i], offset: start})
+		default:
+			found := false
+
+			for _, operator := range expressionOperators {
+				if strings.HasPrefix(source[i:], operator) {
+					tokens = append(tokens, expressionToken{kind: tokenOperator, text: operator, offset: i})
+					i += len(operator)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, expressionToken{kind: tokenEnd, offset: len(source)}), nil
+}
+
+// Parser
+
+type expressionParser struct {
+	tokens    []expressionToken
+	position  int
+	depth     int
+	variables map[string]bool
+}
+
+// The binding power of each binary operator, where operators with a higher power are applied first
+var expressionPrecedence = map[string]int{
+	"||":       1,
+	"&&":       2,
+	"==":       3,
+	"!=":       3,
+	"<":        3,
+	"<=":       3,
+	">":        3,
+	">=":       3,
+	"in":       3,
+	"matches":  3,
+	"contains": 3,
+	"+":        4,
+	"-":        4,
+	"*":        5,
+	"/":        5,
+}
+
+func (parser *expressionParser) peek() expressionToken {
+	return parser.tokens[parser.position]
+}
+
+func (parser *expressionParser) next() expressionToken {
+	token := parser.tokens[parser.position]
+
+	if token.kind != tokenEnd {
+		parser.position++
+	}
+
+	return token
+}
+
+func (parser *expressionParser) expect(operator string) error {
+	if token := parser.next(); token.kind != tokenOperator || token.text != operator {
+		return fmt.Errorf("expected %q at offset %d, but found %s", operator, token.offset, token)
+	}
+
+	return nil
+}
+
+// Get the binary operator at the current position, if there is one
+func (parser *expressionParser) binaryOperator() (string, int) {
+	token := parser.peek()
+
+	if token.kind != tokenOperator && token.kind != tokenIdent {
+		return "", 0
+	}
+
+	precedence, ok := expressionPrecedence[token.text]
+
+	if !ok {
+		return "", 0
+	}
+
+	return token.text, precedence
+}
+
+// Parse an expression containing only binary operators with a higher binding power than the given minimum
+func (parser *expressionParser) parseExpression(minimum int) (expressionNode, error) {
+	if parser.depth++; parser.depth > MaxExpressionDepth {
+		return nil, fmt.Errorf("expression is nested more than %d levels deep", MaxExpressionDepth)
+	}
+
+	defer func() { parser.depth-- }()
+
+	left, err := parser.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator, precedence := parser.binaryOperator()
+
+		if precedence == 0 || precedence <= minimum {
+			return left, nil
+		}
+
+		token := parser.next()
+
+		right, err := parser.parseExpression(precedence)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// comparisons can't be chained, eg. 1 < total < 5
+		if precedence == 3 {
+			if next, nextPrecedence := parser.binaryOperator(); nextPrecedence == 3 {
+				return nil, fmt.Errorf("comparison %q at offset %d can't follow another comparison without parentheses", next, parser.peek().offset)
+			}
+		}
+
+		// patterns must be string literals so that they are compiled once, along with the expression
+		if operator == "matches" {
+			var text string
+
+			pattern, ok := right.(*literalNode)
+
+			if ok {
+				text, ok = pattern.value.(string)
+			}
+
+			if !ok {
+				return nil, fmt.Errorf("matches at offset %d must be followed by a string", token.offset)
+			}
+
+			re, err := regexp.Compile(text)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern for matches at offset %d. %s", token.offset, err)
+			}
+
+			left = &matchesNode{value: left, pattern: re}
+			continue
+		}
+
+		left = &binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+func (parser *expressionParser) parseUnary() (expressionNode, error) {
+	if token := parser.peek(); token.kind == tokenOperator && (token.text == "!" || token.text == "-") {
+		parser.next()
+
+		if parser.depth++; parser.depth > MaxExpressionDepth {
+			return nil, fmt.Errorf("expression is nested more than %d levels deep", MaxExpressionDepth)
+		}
+
+		defer func() { parser.depth-- }()
+
+		value, err := parser.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &unaryNode{operator: token.text, value: value}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *expressionParser) parsePrimary() (expressionNode, error) {
+	token := parser.next()
+
+	switch token.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{value: token.value}, nil
+	case tokenIdent:
+		switch token.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		}
+
+		for _, weekday := range expressionWeekdays {
+			if token.text == weekday {
+				return &literalNode{value: weekday}, nil
+			}
+		}
+
+		if next := parser.peek(); next.kind == tokenOperator && next.text == "(" {
+			return parser.parseCall(token)
+		}
+
+		if !parser.variables[token.text] {
+			return nil, fmt.Errorf("unknown variable %s at offset %d", token.text, token.offset)
+		}
+
+		return &variableNode{name: token.text}, nil
+	case tokenOperator:
+		switch token.text {
+		case "(":
+			value, err := parser.parseExpression(0)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return value, parser.expect(")")
+		case "[":
+			items, err := parser.parseList("]")
+
+			if err != nil {
+				return nil, err
+			}
+
+			return &listNode{items: items}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %s at offset %d", token, token.offset)
+}
+
+func (parser *expressionParser) parseCall(name expressionToken) (expressionNode, error) {
+	function, exists := expressionFunctions[name.text]
+
+	if !exists {
+		return nil, fmt.Errorf("unknown function %s at offset %d", name.text, name.offset)
+	}
+
+	parser.next()
+
+	args, err := parser.parseList(")")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) != function.args {
+		return nil, fmt.Errorf("function %s at offset %d expects %d arguments, but received %d", name.text, name.offset,
+			function.args, len(args))
+	}
+
+	return &callNode{name: name.text, function: function.call, args: args}, nil
+}
+
+// Parse a comma separated list of expressions up to the closing operator
+func (parser *expressionParser) parseList(closing string) ([]expressionNode, error) {
+	items := make([]expressionNode, 0)
+
+	if token := parser.peek(); token.kind == tokenOperator && token.text == closing {
+		parser.next()
+		return items, nil
+	}
+
+	for {
+		item, err := parser.parseExpression(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+
+		token := parser.next()
+
+		if token.kind == tokenOperator && token.text == closing {
+			return items, nil
+		}
+
+		if token.kind != tokenOperator || token.text != "," {
+			return nil, fmt.Errorf("expected \",\" or %q at offset %d, but found %s", closing, token.offset, token)
+		}
+	}
+}
+
+// Evaluator
+
+type expressionEvaluator struct {
+	variables map[string]any
+	steps     int
+	limit     int
+}
+
+// Count a step, failing once the step limit is reached
+func (evaluator *expressionEvaluator) step() error {
+	if evaluator.steps++; evaluator.steps > evaluator.limit {
+		return fmt.Errorf("expression exceeded the limit of %d steps", evaluator.limit)
+	}
+
+	return nil
+}
+
+type literalNode struct {
+	value any
+}
+
+func (node *literalNode) eval(evaluator *expressionEvaluator) (any, error) {
+	return node.value, evaluator.step()
+}
+
+type variableNode struct {
+	name string
+}
+
+func (node *variableNode) eval(evaluator *expressionEvaluator) (any, error) {
+	value, exists := evaluator.variables[node.name]
+
+	if !exists {
+		return nil, fmt.Errorf("variable %s is not available", node.name)
+	}
+
+	return value, evaluator.step()
+}
+
+type listNode struct {
+	items []expressionNode
+}
+
+func (node *listNode) eval(evaluator *expressionEvaluator) (any, error) {
+	if err := evaluator.step(); err != nil {
+		return nil, err
+	}
+
+	values := make([]any, 0, len(node.items))
+
+	for _, item := range node.items {
+		value, err := item.eval(evaluator)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+type unaryNode struct {
+	operator string
+	value    expressionNode
+}
+
+func (node *unaryNode) eval(evaluator *expressionEvaluator) (any, error) {
+	if err := evaluator.step(); err != nil {
+		return nil, err
+	}
+
+	value, err := node.value.eval(evaluator)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.operator {
+	case "!":
+		if b, ok := value.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		if n, ok := value.(*big.Rat); ok {
+			return new(big.Rat).Neg(n), nil
+		}
+	}
+
+	return nil, fmt.Errorf("operator %s can't be applied to a %s", node.operator, typeName(value))
+}
+
+type matchesNode struct {
+	value   expressionNode
+	pattern *regexp.Regexp
+}
+
+func (node *matchesNode) eval(evaluator *expressionEvaluator) (any, error) {
+	if err := evaluator.step(); err != nil {
+		return nil, err
+	}
+
+	value, err := node.value.eval(evaluator)
+
+	if err != nil {
+		return nil, err
+	}
+
+	text, ok := value.(string)
+
+	if !ok {
+		return nil, fmt.Errorf("matches can't be applied to a %s", typeName(value))
+	}
+
+	return node.pattern.MatchString(text), nil
+}
+
+type binaryNode struct {
+	operator string
+	left     expressionNode
+	right    expressionNode
+}
+
+func (node *binaryNode) eval(evaluator *expressionEvaluator) (any, error) {
+	if err := evaluator.step(); err != nil {
+		return nil, err
+	}
+
+	left, err := node.left.eval(evaluator)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// logical operators short circuit
+	if node.operator == "&&" || node.operator == "||" {
+		l, ok := left.(bool)
+
+		if !ok {
+			return nil, fmt.Errorf("operator %s can't be applied to a %s", node.operator, typeName(left))
+		}
+
+		if l == (node.operator == "||") {
+			return l, nil
+		}
+
+		right, err := node.right.eval(evaluator)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if r, ok := right.(bool); ok {
+			return r, nil
+		}
+
+		return nil, fmt.Errorf("operator %s can't be applied to a %s", node.operator, typeName(right))
+	}
+
+	right, err := node.right.eval(evaluator)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.operator {
+	case "==":
+		return expressionEqual(left, right), nil
+	case "!=":
+		return !expressionEqual(left, right), nil
+	case "in":
+		list, ok := right.([]any)
+
+		if !ok {
+			return nil, fmt.Errorf("operator in can't be applied to a %s", typeName(right))
+		}
+
+		for _, item := range list {
+			if expressionEqual(left, item) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	case "contains":
+		if text, ok := left.(string); ok {
+			if substring, ok := right.(string); ok {
+				return strings.Contains(text, substring), nil
+			}
+		}
+
+		if list, ok := left.([]any); ok {
+			for _, item := range list {
+				if expressionEqual(item, right) {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		}
+	case "<", "<=", ">", ">=":
+		comparison, ok := expressionCompare(left, right)
+
+		if !ok {
+			break
+		}
+
+		switch node.operator {
+		case "<":
+			return comparison < 0, nil
+		case "<=":
+			return comparison <= 0, nil
+		case ">":
+			return comparison > 0, nil
+		default:
+			return comparison >= 0, nil
+		}
+	case "+", "-", "*", "/":
+		l, lok := left.(*big.Rat)
+		r, rok := right.(*big.Rat)
+
+		if !lok || !rok {
+			break
+		}
+
+		switch node.operator {
+		case "+":
+			return new(big.Rat).Add(l, r), nil
+		case "-":
+			return new(big.Rat).Sub(l, r), nil
+		case "*":
+			return new(big.Rat).Mul(l, r), nil
+		default:
+			if r.Sign() == 0 {
+				return nil, errors.New("division by zero")
+			}
+
+			return new(big.Rat).Quo(l, r), nil
+		}
+	}
+
+	return nil, fmt.Errorf("operator %s can't be applied to a %s and a %s", node.operator, typeName(left), typeName(right))
+}
+
+type callNode struct {
+	name     string
+	function func(args []any) (any, error)
+	args     []expressionNode
+}
+
+func (node *callNode) eval(evaluator *expressionEvaluator) (any, error) {
+	if err := evaluator.step(); err != nil {
+		return nil, err
+	}
+
+	args := make([]any, 0, len(node.args))
+
+	for _, arg := range node.args {
+		value, err := arg.eval(evaluator)
+
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, value)
+	}
+
+	value, err := node.function(args)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while calling %s. %s", node.name, err)
+	}
+
+	return value, nil
+}
+
+// Check if two values are equal. Values of different types are never equal.
+func expressionEqual(left any, right any) bool {
+	switch l := left.(type) {
+	case *big.Rat:
+		r, ok := right.(*big.Rat)
+		return ok && l.Cmp(r) == 0
+	case string:
+		r, ok := right.(string)
+		return ok && l == r
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	case []any:
+		r, ok := right.([]any)
+
+		if !ok || len(l) != len(r) {
+			return false
+		}
+
+		for i := range l {
+			if !expressionEqual(l[i], r[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Compare two numbers or two strings, returning false if the values can't be compared
+func expressionCompare(left any, right any) (int, bool) {
+	if l, ok := left.(*big.Rat); ok {
+		if r, ok := right.(*big.Rat); ok {
+			return l.Cmp(r), true
+		}
+	}
+
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), true
+		}
+	}
+
+	return 0, false
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case *big.Rat:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []any:
+		return "list"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+type expressionFunction struct {
+	args int
+	call func(args []any) (any, error)
+}
+
+// The functions that can be called from an expression
+var expressionFunctions = map[string]expressionFunction{
+	"len": {args: 1, call: func(args []any) (any, error) {
+		switch value := args[0].(type) {
+		case string:
+			return big.NewRat(int64(len(value)), 1), nil
+		case []any:
+			return big.NewRat(int64(len(value)), 1), nil
+		}
+
+		return nil, fmt.Errorf("len can't be applied to a %s", typeName(args[0]))
+	}},
+	"lower": {args: 1, call: stringFunction(strings.ToLower)},
+	"upper": {args: 1, call: stringFunction(strings.ToUpper)},
+	"trim":  {args: 1, call: stringFunction(strings.TrimSpace)},
+	"floor": {args: 1, call: numberFunction(func(value *big.Rat) *big.Rat {
+		return new(big.Rat).SetInt(floorRat(value))
+	})},
+	"ceil": {args: 1, call: numberFunction(func(value *big.Rat) *big.Rat {
+		return new(big.Rat).SetInt(new(big.Int).Neg(floorRat(new(big.Rat).Neg(value))))
+	})},
+	"min": {args: 2, call: func(args []any) (any, error) {
+		comparison, ok := expressionCompare(args[0], args[1])
+
+		if !ok {
+			return nil, fmt.Errorf("min can't be applied to a %s and a %s", typeName(args[0]), typeName(args[1]))
+		}
+
+		if comparison <= 0 {
+			return args[0], nil
+		}
+
+		return args[1], nil
+	}},
+	"max": {args: 2, call: func(args []any) (any, error) {
+		comparison, ok := expressionCompare(args[0], args[1])
+
+		if !ok {
+			return nil, fmt.Errorf("max can't be applied to a %s and a %s", typeName(args[0]), typeName(args[1]))
+		}
+
+		if comparison >= 0 {
+			return args[0], nil
+		}
+
+		return args[1], nil
+	}},
+}
+
+func stringFunction(function func(string) string) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		if value, ok := args[0].(string); ok {
+			return function(value), nil
+		}
+
+		return nil, fmt.Errorf("expected a string, but received a %s", typeName(args[0]))
+	}
+}
+
+func numberFunction(function func(*big.Rat) *big.Rat) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		if value, ok := args[0].(*big.Rat); ok {
+			return function(value), nil
+		}
+
+		return nil, fmt.Errorf("expected a number, but received a %s", typeName(args[0]))
+	}
+}
+
+// Round a number down to the nearest integer
+func floorRat(value *big.Rat) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+
+	// QuoRem truncates toward zero, so negative values with a remainder are one too high
+	if remainder.Sign() < 0 {
+		quotient.Sub(quotient, big.NewInt(1))
+	}
+
+	return quotient
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestExpressionPromotion(t *testing.T) {
+	expression, err := CompileExpression(`retailer matches "(?i)target" && total >= 20 && weekday in [SAT, SUN]`, receiptExpressionVariables)
+
+	if err != nil {
+		t.Fatalf("unexpected error while compiling expression. %s", err)
+	}
+
+	// 2022-03-20 is a Sunday and 2022-03-21 is a Monday
+	cases := []struct {
+		retailer     string
+		purchaseDate string
+		total        string
+		expected     bool
+	}{
+		{"Target", "2022-03-20", "35.35", true},
+		{"SuperTarget", "2022-03-20", "20.00", true},
+		{"Target", "2022-03-20", "19.99", false},
+		{"Target", "2022-03-21", "35.35", false},
+		{"M&M Corner Market", "2022-03-20", "35.35", false},
+	}
+
+	for _, test := range cases {
+		values, err := receiptExpressionValues(&Receipt{
+			Retailer:      test.retailer,
+			PurchaseDate:  test.purchaseDate,
+			PurchaseTime:  "14:33",
+			PurchaseTotal: test.total,
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error while getting receipt values. %s", err)
+		}
+
+		if result, err := expression.EvaluateBool(values); err != nil || result != test.expected {
+			t.Errorf("expected %t for %s on %s with total %s, but received %t with error %v", test.expected, test.retailer,
+				test.purchaseDate, test.total, result, err)
+		}
+	}
+}
+
+func TestExpressionEvaluate(t *testing.T) {
+	variables := map[string]any{
+		"name":  "Gatorade",
+		"price": big.NewRat(225, 100),
+		"count": big.NewRat(3, 1),
+	}
+
+	cases := map[string]string{
+		`1 + 2 * 3`:                          "7",
+		`(1 + 2) * 3`:                        "9",
+		`-price * 2`:                         "-9/2",
+		`floor(price * 1.5)`:                 "3",
+		`ceil(price)`:                        "3",
+		`max(1, count) - min(4, 5)`:          "-1",
+		`len(name) + len([1, 2])`:            "10",
+		`upper(trim("  ade ")) == "ADE"`:     "true",
+		`lower(name) contains "ade"`:         "true",
+		`count in [1, 2, 3] && !(price > 3)`: "true",
+		`1 < 2 || 1 / 0 == 1`:                "true",
+		`1 > 2 && 1 / 0 == 1`:                "false",
+		`name != "Pepsi" && name < "Pepsi"`:  "true",
+		`"1" == 1`:                           "false",
+	}
+
+	for source, expected := range cases {
+		expression, err := CompileExpression(source, []string{"name", "price", "count"})
+
+		if err != nil {
+			t.Errorf("unexpected error while compiling %s. %s", source, err)
+			continue
+		}
+
+		value, err := expression.Evaluate(variables)
+
+		if err != nil {
+			t.Errorf("unexpected error while evaluating %s. %s", source, err)
+			continue
+		}
+
+		var result string
+
+		switch v := value.(type) {
+		case *big.Rat:
+			result = v.RatString()
+		case bool:
+			if v {
+				result = "true"
+			} else {
+				result = "false"
+			}
+		}
+
+		if result != expected {
+			t.Errorf("expected %s to result in %s, but received %v", source, expected, value)
+		}
+	}
+}
+
+func TestCompileExpressionInvalid(t *testing.T) {
+	cases := map[string]string{
+		"unknown variable":   `stars > 5`,
+		"bad regexp":         `name matches "("`,
+		"dynamic regexp":     `name matches name`,
+		"chained comparison": `1 < 2 < 3`,
+		"unterminated":       `name == "abc`,
+		"unknown function":   `exec("rm")`,
+		"missing operand":    `1 +`,
+		"unbalanced":         `(1 + 2`,
+		"trailing token":     `1 2`,
+		"unknown character":  `name == 'a'`,
+		"too long":           strings.Repeat("1 + ", MaxExpressionLength/4) + "1",
+		"too deep":           strings.Repeat("(", MaxExpressionDepth+1) + "1" + strings.Repeat(")", MaxExpressionDepth+1),
+		"non ascii name":     `nämе == 1`,
+		"wrong arity":        `len(name, name)`,
+	}
+
+	for name, source := range cases {
+		if _, err := CompileExpression(source, []string{"name"}); err == nil {
+			t.Errorf("expected error while compiling expression with %s, but received nil", name)
+		}
+	}
+}
+
+func TestExpressionRuntimeErrors(t *testing.T) {
+	variables := map[string]any{"name": "Target", "total": big.NewRat(0, 1)}
+
+	cases := map[string]string{
+		"division by zero":  `10 / total`,
+		"mismatched types":  `name + 1`,
+		"bad comparison":    `name > 1`,
+		"string addition":   `name + name`,
+		"bad negation":      `!name`,
+		"bad argument":      `floor(name)`,
+		"not a boolean":     `name && true`,
+		"compare to a list": `[1] < [2]`,
+	}
+
+	for name, source := range cases {
+		expression, err := CompileExpression(source, []string{"name", "total"})
+
+		if err != nil {
+			t.Errorf("unexpected error while compiling expression with %s. %s", name, err)
+			continue
+		}
+
+		if _, err := expression.Evaluate(variables); err == nil {
+			t.Errorf("expected error while evaluating expression with %s, but received nil", name)
+		}
+	}
+
+	// the result type is checked by the typed evaluations
+	expression, _ := CompileExpression(`name`, []string{"name"})
+
+	if _, err := expression.EvaluateBool(variables); err == nil {
+		t.Error("expected error while evaluating a string as a boolean, but received nil")
+	}
+
+	if _, err := expression.EvaluateNumber(variables); err == nil {
+		t.Error("expected error while evaluating a string as a number, but received nil")
+	}
+}
+
+func TestExpressionStepLimit(t *testing.T) {
+	expression, err := CompileExpression(strings.Repeat("1 + ", 100)+"1", nil)
+
+	if err != nil {
+		t.Fatalf("unexpected error while compiling expression. %s", err)
+	}
+
+	if value, err := expression.EvaluateNumber(nil); err != nil || value.RatString() != "101" {
+		t.Fatalf("expected 101 within the default step limit, but received %v with error %v", value, err)
+	}
+
+	expression.StepLimit = 50
+
+	if _, err := expression.Evaluate(nil); err == nil || !strings.Contains(err.Error(), "limit of 50 steps") {
+		t.Errorf("expected the step limit to be exceeded, but received %v", err)
+	}
+}
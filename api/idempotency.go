@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The stored outcome of a request made with an Idempotency-Key header. Retries with the same key and request body receive the
+// original response instead of being processed again.
+type IdempotencyRecord struct {
+	Key         string          `json:"key"`
+	RequestHash string          `json:"requestHash"`
+	StatusCode  int             `json:"statusCode"`
+	Response    json.RawMessage `json:"response"`
+	ExpiresAt   time.Time       `json:"expiresAt"`
+
+	// set while the original request is still being processed. Pending records are never written to storage.
+	Pending bool `json:"-"`
+}
+
+// Check if the record can no longer be replayed
+func (record *IdempotencyRecord) IsExpired(now time.Time) bool {
+	return !now.Before(record.ExpiresAt)
+}
+
+// Reserve an idempotency key for a new request. If the key is already in use by an unexpired record, that record is returned
+// instead and nothing is reserved.
+func (db ReceiptDatabase) ReserveIdempotencyKey(key string, requestHash string, expiresAt time.Time) (*IdempotencyRecord, bool, error) {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	raw, err := txn.First("idempotency", "id", key)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("error while querying database for idempotency key %s. %s", key, err)
+	}
+
+	if raw != nil && !raw.(*IdempotencyRecord).IsExpired(time.Now()) {
+		return raw.(*IdempotencyRecord), false, nil
+	}
+
+	record := &IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   expiresAt,
+		Pending:     true,
+	}
+
+	if err := txn.Insert("idempotency", record); err != nil {
+		return nil, false, fmt.Errorf("unable to reserve idempotency key %s. %s", key, err)
+	}
+
+	txn.Commit()
+
+	return record, true, nil
+}
+
+// Store the response for a reserved idempotency key so that it can be replayed
+func (db ReceiptDatabase) CompleteIdempotencyKey(reserved *IdempotencyRecord, statusCode int, response json.RawMessage) error {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	// the completed record replaces the reservation
+	record := &IdempotencyRecord{
+		Key:         reserved.Key,
+		RequestHash: reserved.RequestHash,
+		StatusCode:  statusCode,
+		Response:    response,
+		ExpiresAt:   reserved.ExpiresAt,
+	}
+
+	txn := db.MemDB.Txn(true)
+
+	if err := txn.Insert("idempotency", record); err != nil {
+		txn.Abort()
+
+		return fmt.Errorf("unable to store response for idempotency key %s. %s", record.Key, err)
+	}
+
+	if err := db.Storage.Append(StorageRecord{Type: StorageRecordIdempotency, Idempotency: record}); err != nil {
+		txn.Abort()
+
+		return fmt.Errorf("unable to persist response for idempotency key %s. %s", record.Key, err)
+	}
+
+	txn.Commit()
+
+	return nil
+}
+
+// Release a reserved idempotency key without storing a response, so that the request can be retried
+func (db ReceiptDatabase) ReleaseIdempotencyKey(reserved *IdempotencyRecord) error {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+
+	if _, err := txn.DeleteAll("idempotency", "id", reserved.Key); err != nil {
+		txn.Abort()
+
+		return fmt.Errorf("unable to release idempotency key %s. %s", reserved.Key, err)
+	}
+
+	txn.Commit()
+
+	return nil
+}
+
+// Delete every expired idempotency key, returning the number of keys deleted. Expired keys are never restored from storage,
+// so nothing is written to storage.
+func (db ReceiptDatabase) PurgeExpiredIdempotencyKeys(now time.Time) (int, error) {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	it, err := txn.Get("idempotency", "id")
+
+	if err != nil {
+		return 0, fmt.Errorf("error while querying expired idempotency keys. %s", err)
+	}
+
+	// the table can't be changed while it is being iterated, so expired keys are collected first
+	expired := make([]*IdempotencyRecord, 0)
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		if record := raw.(*IdempotencyRecord); record.IsExpired(now) {
+			expired = append(expired, record)
+		}
+	}
+
+	for _, record := range expired {
+		if err := txn.Delete("idempotency", record); err != nil {
+			return 0, fmt.Errorf("unable to delete expired idempotency key %s. %s", record.Key, err)
+		}
+	}
+
+	txn.Commit()
+
+	return len(expired), nil
+}
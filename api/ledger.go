@@ -0,0 +1,283 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-memdb"
+)
+
+// Ledger entry types
+const (
+	// points earned by a receipt submitted for the customer
+	LedgerEntryEarn = "earn"
+
+	// a change in the points of a receipt after it was re-scored
+	LedgerEntryAdjust = "adjust"
+
+	// points spent by the customer
+	LedgerEntryRedeem = "redeem"
+
+	// points set aside by a hold, which can no longer be spent until the hold is released
+	LedgerEntryHold = "hold"
+
+	// held points that were spent when the hold was captured
+	LedgerEntryCapture = "capture"
+
+	// held points returned to the balance when the hold was released or expired
+	LedgerEntryRelease = "release"
+
+	// points taken back when the receipt that earned them is reversed
+	LedgerEntryReverse = "reverse"
+
+	// points that were not spent before they expired
+	LedgerEntryExpire = "expire"
+)
+
+// A single change to the points of a customer. The ledger is append-only, so entries are never modified or removed once written,
+// and the balance of a customer is the balance of their latest entry.
+//
+// Every entry moves the points earned by a single receipt, so the points of a customer can be traced back to the receipts that
+// earned them. Points is the change in the spendable balance and HeldPoints is the change in the points set aside by holds.
+type LedgerEntry struct {
+	Id         string `json:"id"`
+	CustomerId string `json:"customerId"`
+
+	// the position of the entry in the ledger of the customer, starting from 1
+	Sequence uint64 `json:"sequence"`
+
+	Type       string `json:"type"`
+	Points     int    `json:"points"`
+	Balance    int    `json:"balance"`
+	HeldPoints int    `json:"heldPoints,omitempty"`
+	Held       int    `json:"held"`
+	ReceiptId  string `json:"receiptId"`
+
+	// groups the entries written by a single redemption or hold
+	TransactionId string `json:"transactionId,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// The points earned by a single receipt that remain with the customer, either spendable or held
+type ledgerLot struct {
+	ReceiptId string
+	Available int
+	Held      int
+	Reversed  bool
+}
+
+// A single page of ledger entries, oldest first. NextCursor is empty when there are no more entries to return.
+type LedgerPage struct {
+	Entries    []*LedgerEntry `json:"entries"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// Get the latest ledger entry of a customer, or nil if the customer has no entries
+func lastLedgerEntry(txn *memdb.Txn, customerId string) (*LedgerEntry, error) {
+	it, err := txn.ReverseLowerBound("ledger", "customer", customerId, uint64(math.MaxUint64))
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying ledger for customer %s. %s", customerId, err)
+	}
+
+	if raw := it.Next(); raw != nil && raw.(*LedgerEntry).CustomerId == customerId {
+		return raw.(*LedgerEntry), nil
+	}
+
+	return nil, nil
+}
+
+// Append an entry to the ledger of a customer within a write transaction, filling in the id, sequence and running balance. The
+// entry is returned as a storage record so that it can be persisted along with the rest of the transaction.
+func appendLedgerEntry(txn *memdb.Txn, entry *LedgerEntry) (StorageRecord, error) {
+	last, err := lastLedgerEntry(txn, entry.CustomerId)
+
+	if err != nil {
+		return StorageRecord{}, err
+	}
+
+	entry.Id = uuid.New().String()
+	entry.Sequence = 1
+	entry.Balance = entry.Points
+	entry.Held = entry.HeldPoints
+
+	if last != nil {
+		entry.Sequence = last.Sequence + 1
+		entry.Balance = last.Balance + entry.Points
+		entry.Held = last.Held + entry.HeldPoints
+	}
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	if err := txn.Insert("ledger", entry); err != nil {
+		return StorageRecord{}, fmt.Errorf("unable to append ledger entry for customer %s. %s", entry.CustomerId, err)
+	}
+
+	return StorageRecord{Type: StorageRecordLedger, LedgerEntry: entry}, nil
+}
+
+// Get the points remaining from each receipt of a customer, in the order the receipts were first credited to the customer
+func customerLots(txn *memdb.Txn, customerId string) ([]*ledgerLot, error) {
+	it, err := txn.LowerBound("ledger", "customer", customerId, uint64(1))
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying ledger for customer %s. %s", customerId, err)
+	}
+
+	lots := make([]*ledgerLot, 0)
+	receipts := make(map[string]*ledgerLot)
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		entry := raw.(*LedgerEntry)
+
+		if entry.CustomerId != customerId {
+			break
+		}
+
+		lot, exists := receipts[entry.ReceiptId]
+
+		if !exists {
+			lot = &ledgerLot{ReceiptId: entry.ReceiptId}
+			receipts[entry.ReceiptId] = lot
+			lots = append(lots, lot)
+		}
+
+		lot.Available += entry.Points
+		lot.Held += entry.HeldPoints
+		lot.Reversed = lot.Reversed || entry.Type == LedgerEntryReverse
+	}
+
+	return lots, nil
+}
+
+// Get the points a receipt earned for its customer that have not expired, including any adjustments, and whether they were
+// reversed. Points that were spent or held still count, since they were earned by the receipt.
+func receiptEarnings(txn *memdb.Txn, receipt *Receipt) (int, bool, error) {
+	it, err := txn.LowerBound("ledger", "customer", receipt.CustomerId, uint64(1))
+
+	if err != nil {
+		return 0, false, fmt.Errorf("error while querying ledger for customer %s. %s", receipt.CustomerId, err)
+	}
+
+	earned := 0
+	reversed := false
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		entry := raw.(*LedgerEntry)
+
+		if entry.CustomerId != receipt.CustomerId {
+			break
+		}
+
+		if entry.ReceiptId != receipt.GetId() {
+			continue
+		}
+
+		switch entry.Type {
+		case LedgerEntryEarn, LedgerEntryAdjust, LedgerEntryExpire:
+			earned += entry.Points
+		case LedgerEntryReverse:
+			reversed = true
+		}
+	}
+
+	return earned, reversed, nil
+}
+
+// Record a change in the points of a receipt as an adjustment to the ledger of its customer, rather than by changing the earn
+// entry. Nothing is recorded for receipts without a customer, or whose points were reversed, since they no longer earn anything.
+// Points that already expired are not taken back again.
+func adjustReceiptPoints(txn *memdb.Txn, receipt *Receipt, change int) ([]StorageRecord, error) {
+	if receipt.CustomerId == "" || change == 0 {
+		return nil, nil
+	}
+
+	earned, reversed, err := receiptEarnings(txn, receipt)
+
+	if err != nil || reversed {
+		return nil, err
+	}
+
+	if change = max(change, -max(earned, 0)); change == 0 {
+		return nil, nil
+	}
+
+	record, err := appendLedgerEntry(txn, &LedgerEntry{
+		CustomerId: receipt.CustomerId,
+		Type:       LedgerEntryAdjust,
+		Points:     change,
+		ReceiptId:  receipt.GetId(),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []StorageRecord{record}, nil
+}
+
+// Get the current spendable and held points of a customer
+func (db ReceiptDatabase) GetCustomerBalance(customerId string) (int, int, error) {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	last, err := lastLedgerEntry(txn, customerId)
+
+	if err != nil || last == nil {
+		return 0, 0, err
+	}
+
+	return last.Balance, last.Held, nil
+}
+
+// Get a single page of the ledger of a customer, oldest entry first
+func (db ReceiptDatabase) GetLedger(customerId string, cursor string, limit int) (*LedgerPage, error) {
+	start := uint64(1)
+
+	if cursor != "" {
+		key, err := decodeCursor("ledger", cursor)
+
+		if err != nil || len(key) != 8 {
+			return nil, fmt.Errorf("invalid ledger cursor")
+		}
+
+		start = binary.BigEndian.Uint64(key) + 1
+	}
+
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	it, err := txn.LowerBound("ledger", "customer", customerId, start)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying ledger for customer %s. %s", customerId, err)
+	}
+
+	page := &LedgerPage{
+		Entries: make([]*LedgerEntry, 0),
+	}
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		entry := raw.(*LedgerEntry)
+
+		if entry.CustomerId != customerId {
+			break
+		}
+
+		// there are more entries than fit on this page
+		if len(page.Entries) == limit {
+			page.NextCursor = encodeCursor("ledger", binary.BigEndian.AppendUint64(nil, page.Entries[limit-1].Sequence))
+			break
+		}
+
+		page.Entries = append(page.Entries, entry)
+	}
+
+	return page, nil
+}
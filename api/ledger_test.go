@@ -0,0 +1,113 @@
+package api
+
+import (
+	"testing"
+)
+
+// Insert a copy of an example receipt for a customer, with the purchase time changed so that it isn't a duplicate
+func insertCustomerReceipt(t *testing.T, db *ReceiptDatabase, customerId string, purchaseTime string) *Receipt {
+	receipt := &Receipt{
+		Retailer:      "Target",
+		PurchaseDate:  "2022-01-02",
+		PurchaseTime:  purchaseTime,
+		PurchaseTotal: "1.25",
+		Items:         []ReceiptItem{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+		CustomerId:    customerId,
+	}
+
+	if _, err := db.InsertReceipt(receipt); err != nil {
+		t.Fatalf("unexpected error while inserting receipt. %s", err)
+	}
+
+	return receipt
+}
+
+func TestCustomerLedger(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, err := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	if err != nil {
+		t.Fatalf("unexpected error while inserting customer. %s", err)
+	}
+
+	other, _ := db.InsertCustomer(&CustomerInput{Name: "Grace"})
+
+	// each receipt earns 31 points, like example receipt B
+	insertCustomerReceipt(t, db, customer.Id, "13:14")
+	insertCustomerReceipt(t, db, customer.Id, "09:13")
+	insertCustomerReceipt(t, db, other.Id, "10:13")
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != 62 {
+		t.Errorf("expected a balance of 62, but received %d", balance)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(other.Id); balance != 31 {
+		t.Errorf("expected a balance of 31, but received %d", balance)
+	}
+
+	// entries are returned in order, one page at a time
+	page, err := db.GetLedger(customer.Id, "", 1)
+
+	if err != nil {
+		t.Fatalf("unexpected error while querying ledger. %s", err)
+	}
+
+	if len(page.Entries) != 1 || page.Entries[0].Sequence != 1 || page.Entries[0].Type != LedgerEntryEarn || page.Entries[0].Points != 31 {
+		t.Fatalf("expected the first earn entry of 31 points, but received %v", page.Entries)
+	}
+
+	page, _ = db.GetLedger(customer.Id, page.NextCursor, 1)
+
+	if len(page.Entries) != 1 || page.Entries[0].Sequence != 2 || page.Entries[0].Balance != 62 || page.NextCursor != "" {
+		t.Fatalf("expected the last entry with a balance of 62, but received %v", page)
+	}
+
+	if _, err := db.GetLedger(customer.Id, "nope", 1); err == nil {
+		t.Error("expected error while querying ledger with an invalid cursor, but received nil")
+	}
+
+	// re-scoring a receipt appends an adjustment instead of changing the earn entry, leaving 6 points for each receipt
+	rules, _ := NewRuleSet([]string{"retailer-name"})
+	rules.Version = "2"
+
+	if _, err := db.RescoreReceipts(rules, func(receipt *Receipt) bool { return receipt.CustomerId == customer.Id }, false); err != nil {
+		t.Fatalf("unexpected error while re-scoring receipts. %s", err)
+	}
+
+	page, _ = db.GetLedger(customer.Id, "", DefaultQueryLimit)
+
+	if len(page.Entries) != 4 || page.Entries[3].Type != LedgerEntryAdjust || page.Entries[3].Points != -25 || page.Entries[3].Balance != 12 {
+		t.Errorf("expected adjustments of -25 points to a balance of 12, but received %v", page.Entries)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(other.Id); balance != 31 {
+		t.Errorf("expected the balance of another customer to be unchanged, but received %d", balance)
+	}
+}
+
+func TestCustomerLedgerRestore(t *testing.T) {
+	config := &Config{StorageBackend: StorageBackendFile, StoragePath: t.TempDir()}
+
+	db := SetupDatabase(config, DefaultRuleSet())
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	insertCustomerReceipt(t, db, customer.Id, "13:14")
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error while closing database. %s", err)
+	}
+
+	restored := SetupDatabase(config, DefaultRuleSet())
+	defer restored.Close()
+
+	if _, err := restored.GetCustomerById(customer.Id); err != nil {
+		t.Errorf("expected customer to be restored. %s", err)
+	}
+
+	// new entries continue the restored ledger
+	insertCustomerReceipt(t, restored, customer.Id, "09:13")
+
+	if balance, _, _ := restored.GetCustomerBalance(customer.Id); balance != 62 {
+		t.Errorf("expected a balance of 62, but received %d", balance)
+	}
+}
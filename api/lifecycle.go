@@ -0,0 +1,483 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-memdb"
+)
+
+// Receipt statuses. A receipt is active until it is voided or every item is refunded, after which it is closed and no longer
+// earns points.
+const (
+	ReceiptStatusActive            = "active"
+	ReceiptStatusVoided            = "voided"
+	ReceiptStatusRefunded          = "refunded"
+	ReceiptStatusPartiallyRefunded = "partially_refunded"
+)
+
+// A change in the status of a receipt, kept for audit
+type ReceiptStatusChange struct {
+	Status string `json:"status"`
+
+	// the points of the receipt after the change
+	Points int `json:"points"`
+
+	Reason    string    `json:"reason,omitempty"`
+	RefundId  string    `json:"refundId,omitempty"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// Items returned from a receipt, given by their position in the items of the receipt
+type ReceiptRefund struct {
+	Id           string    `json:"id"`
+	Items        []int     `json:"items"`
+	Amount       string    `json:"amount"`
+	PointsChange int       `json:"pointsChange"`
+	Reason       string    `json:"reason,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Returned when a receipt is already closed
+type ReceiptStatusError struct {
+	Id     string
+	Status string
+}
+
+func (err *ReceiptStatusError) Error() string {
+	return fmt.Sprintf("receipt %s is %s", err.Id, err.Status)
+}
+
+// Returned when the items of a refund are invalid
+type RefundError struct {
+	Reason string
+}
+
+func (err *RefundError) Error() string {
+	return fmt.Sprintf("invalid refund. %s", err.Reason)
+}
+
+// Get the status of the receipt. Receipts stored before statuses were introduced are active.
+func (receipt *Receipt) GetStatus() string {
+	if receipt.Status == "" {
+		return ReceiptStatusActive
+	}
+
+	return receipt.Status
+}
+
+// Check whether the receipt has been voided or fully refunded
+func (receipt *Receipt) IsClosed() bool {
+	status := receipt.GetStatus()
+
+	return status == ReceiptStatusVoided || status == ReceiptStatusRefunded
+}
+
+// Get a copy of the receipt as it stands after its refunds, without the refunded items and with their prices taken off the
+// total. This is the receipt that earns points. The copy never shares items with the receipt, so it can be scored even when
+// the receipt is visible to readers.
+func (receipt *Receipt) GetRemaining() *Receipt {
+	if len(receipt.Refunds) == 0 {
+		remaining := *receipt
+		remaining.Items = slices.Clone(receipt.Items)
+
+		return &remaining
+	}
+
+	refunded := make(map[int]bool)
+
+	for _, refund := range receipt.Refunds {
+		for _, index := range refund.Items {
+			refunded[index] = true
+		}
+	}
+
+	remaining := *receipt
+	total, _ := remaining.GetPurchaseTotal()
+
+	remaining.Items = make([]ReceiptItem, 0, len(receipt.Items))
+	remaining.parsedPurchaseTotal = nil
+
+	for i, item := range receipt.Items {
+		if refunded[i] {
+			price, _ := item.GetPrice()
+			total -= price
+
+			continue
+		}
+
+		remaining.Items = append(remaining.Items, item)
+	}
+
+	remaining.PurchaseTotal = max(total, 0).String()
+
+	return &remaining
+}
+
+// The rule result that keeps a refund from adding points to a receipt
+const RefundCapRule = "refund-cap"
+
+// Calculate the points of a receipt without its refunded items, using a rule set and the loyalty tier stored on the receipt.
+// Removing items can make a receipt earn more points (eg. a round dollar total), so the base points are capped at the lowest
+// base points the receipt had after any of its refunds, or before the first. The cap is its own result, so the breakdown always
+// adds up to the points of the receipt, and it is applied before the tier multiplier, so the base points and points are capped
+// together.
+func (rules *RuleSet) GetReceiptPointsBreakdown(receipt *Receipt) (*PointsBreakdown, error) {
+	breakdown, err := rules.getBasePointsBreakdown(receipt.GetRemaining())
+
+	if err != nil {
+		return nil, err
+	}
+
+	lowest := breakdown.Points
+
+	for i := range receipt.Refunds {
+		before := *receipt
+		before.Refunds = receipt.Refunds[:i]
+
+		previous, err := rules.getBasePointsBreakdown(before.GetRemaining())
+
+		if err != nil {
+			return nil, err
+		}
+
+		lowest = min(lowest, previous.Points)
+	}
+
+	if lowest < breakdown.Points {
+		breakdown.Results = append(breakdown.Results, RuleResult{
+			Rule:   RefundCapRule,
+			Points: lowest - breakdown.Points,
+			Reason: fmt.Sprintf("a refund never adds points, so the points are capped at %d", lowest),
+		})
+
+		breakdown.Points = lowest
+	}
+
+	if err := breakdown.applyTier(receipt.Tier); err != nil {
+		return nil, err
+	}
+
+	return breakdown, nil
+}
+
+// Recalculate the points of a receipt using a rule set and the loyalty tier stored on it, storing them on the receipt along with
+// the rules version. This must only be called before the receipt is inserted.
+func (receipt *Receipt) rescore(rules *RuleSet) error {
+	breakdown, err := rules.GetReceiptPointsBreakdown(receipt)
+
+	if err != nil {
+		return fmt.Errorf("error while calculating points for receipt %s. %s", receipt.GetId(), err)
+	}
+
+	receipt.Points = &breakdown.Points
+	receipt.BasePoints = &breakdown.BasePoints
+	receipt.RulesVersion = rules.Version
+	receipt.RulesFingerprint = rules.Fingerprint()
+
+	return nil
+}
+
+// Record a change in the status of a receipt. This must only be called before the receipt is inserted.
+func (receipt *Receipt) setStatus(status string, reason string, now time.Time) *ReceiptStatusChange {
+	points := 0
+
+	if receipt.Points != nil {
+		points = *receipt.Points
+	}
+
+	receipt.Status = status
+
+	// the history may be shared with the original receipt, so it is copied rather than appended to in place
+	receipt.StatusHistory = append(slices.Clip(receipt.StatusHistory), ReceiptStatusChange{
+		Status:    status,
+		Points:    points,
+		Reason:    reason,
+		ChangedAt: now,
+	})
+
+	return &receipt.StatusHistory[len(receipt.StatusHistory)-1]
+}
+
+// Take back every point a closed receipt earned for its customer, unless they were already reversed
+func closeReceiptPoints(txn *memdb.Txn, receipt *Receipt) ([]StorageRecord, error) {
+	if receipt.CustomerId == "" {
+		return nil, nil
+	}
+
+	if _, reversed, err := receiptEarnings(txn, receipt); err != nil || reversed {
+		return nil, err
+	}
+
+	_, record, err := reverseReceiptPoints(txn, receipt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []StorageRecord{record}, nil
+}
+
+// Run a change to the lifecycle of a receipt in a single write transaction. The change is given a copy of the receipt, which
+// replaces the original along with every record returned by the change.
+func (db ReceiptDatabase) updateReceipt(id string, change func(txn *memdb.Txn, updated *Receipt, now time.Time) ([]StorageRecord, error)) (*Receipt, error) {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	raw, err := txn.First("receipt", "id", id)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying database for receipt %s. %s", id, err)
+	}
+
+	if raw == nil {
+		return nil, fmt.Errorf("no receipt exists with id %s", id)
+	}
+
+	receipt := raw.(*Receipt)
+
+	if receipt.IsClosed() {
+		return nil, &ReceiptStatusError{Id: id, Status: receipt.GetStatus()}
+	}
+
+	// an updated copy replaces the original, with its own items since a refund caches parsed prices on them
+	updated := *receipt
+	updated.Items = slices.Clone(receipt.Items)
+
+	records, err := change(txn, &updated, time.Now().UTC())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := txn.Insert("receipt", &updated); err != nil {
+		return nil, fmt.Errorf("unable to update receipt %s. %s", id, err)
+	}
+
+	if err := db.Storage.Append(append([]StorageRecord{{Type: StorageRecordReceipt, Receipt: &updated}}, records...)...); err != nil {
+		return nil, fmt.Errorf("unable to persist receipt %s. %s", id, err)
+	}
+
+	txn.Commit()
+
+	return &updated, nil
+}
+
+// Void a receipt, taking back the points it earned
+func (db ReceiptDatabase) VoidReceipt(id string, reason string) (*Receipt, error) {
+	return db.updateReceipt(id, func(txn *memdb.Txn, updated *Receipt, now time.Time) ([]StorageRecord, error) {
+		updated.Points = new(int)
+		updated.setStatus(ReceiptStatusVoided, reason, now)
+
+		return closeReceiptPoints(txn, updated)
+	})
+}
+
+// Refund items of a receipt, given by their position in the items of the receipt. The points of a partially refunded receipt
+// are recalculated without the refunded items using a rule set, but never increase, while a fully refunded receipt gives back
+// every point it earned.
+func (db ReceiptDatabase) RefundReceipt(id string, items []int, reason string, rules *RuleSet) (*Receipt, *ReceiptRefund, error) {
+	var refund *ReceiptRefund
+
+	receipt, err := db.updateReceipt(id, func(txn *memdb.Txn, updated *Receipt, now time.Time) ([]StorageRecord, error) {
+		if len(items) == 0 {
+			return nil, &RefundError{Reason: "at least one item must be refunded"}
+		}
+
+		refunded := make(map[int]bool)
+
+		for _, previous := range updated.Refunds {
+			for _, index := range previous.Items {
+				refunded[index] = true
+			}
+		}
+
+		amount := Money(0)
+
+		for _, index := range items {
+			if index < 0 || index >= len(updated.Items) {
+				return nil, &RefundError{Reason: fmt.Sprintf("item %d does not exist", index)}
+			}
+
+			if refunded[index] {
+				return nil, &RefundError{Reason: fmt.Sprintf("item %d has already been refunded", index)}
+			}
+
+			refunded[index] = true
+
+			price, err := updated.Items[index].GetPrice()
+
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse price of item %d. %s", index, err)
+			}
+
+			if amount, err = amount.Add(price); err != nil {
+				return nil, err
+			}
+		}
+
+		oldPoints := 0
+
+		if updated.Points != nil {
+			oldPoints = *updated.Points
+		}
+
+		// the refunds may be shared with the original receipt, so they are copied rather than appended to in place
+		updated.Refunds = append(slices.Clip(updated.Refunds), ReceiptRefund{
+			Id:        uuid.New().String(),
+			Items:     items,
+			Amount:    amount.String(),
+			Reason:    reason,
+			CreatedAt: now,
+		})
+
+		refund = &updated.Refunds[len(updated.Refunds)-1]
+
+		if len(refunded) == len(updated.Items) {
+			updated.Points = new(int)
+			refund.PointsChange = -oldPoints
+			updated.setStatus(ReceiptStatusRefunded, reason, now).RefundId = refund.Id
+
+			return closeReceiptPoints(txn, updated)
+		}
+
+		// the loyalty tier the receipt was awarded is kept, and the points are capped so that a refund never adds points
+		if err := updated.rescore(rules); err != nil {
+			return nil, err
+		}
+
+		refund.PointsChange = *updated.Points - oldPoints
+		updated.setStatus(ReceiptStatusPartiallyRefunded, reason, now).RefundId = refund.Id
+
+		return adjustReceiptPoints(txn, updated, refund.PointsChange)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return receipt, refund, nil
+}
+
+type VoidRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+type RefundRequest struct {
+	// the position of each refunded item in the items of the receipt, starting from 0
+	Items  []int  `json:"items" binding:"required,min=1"`
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// Void a receipt, taking back the points it earned
+// POST /receipts/{id}/void
+func (api ReceiptsApi) HandleVoidReceipt(c *gin.Context) {
+	receipt, found := api.lookupReceipt(c)
+
+	if !found {
+		return
+	}
+
+	var request VoidRequest
+
+	// the reason is optional, so an empty body is allowed
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{
+				"error":   "The void is invalid.",
+				"details": bindingValidationErrors(err),
+			})
+
+			return
+		}
+	}
+
+	voided, err := api.Database.VoidReceipt(receipt.GetId(), request.Reason)
+
+	if err != nil {
+		c.JSON(receiptStatusErrorResponse(err, "error while voiding receipt %s", receipt.GetId()))
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"id":     voided.GetId(),
+		"status": voided.Status,
+		"points": *voided.Points,
+	})
+}
+
+// Refund items of a receipt, recalculating the points it earned
+// POST /receipts/{id}/refunds
+func (api ReceiptsApi) HandleCreateRefund(c *gin.Context) {
+	receipt, found := api.lookupReceipt(c)
+
+	if !found {
+		return
+	}
+
+	// process each request at most once per idempotency key
+	process := func(c *gin.Context) (int, gin.H) {
+		var request RefundRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return 400, gin.H{
+				"error":   "The refund is invalid.",
+				"details": bindingValidationErrors(err),
+			}
+		}
+
+		// recalculate the points using the rule set that awarded them, falling back to the current rules if that version is unknown
+		rules, exists := api.RuleSets.Get(receipt.RulesVersion)
+
+		if !exists {
+			rules = api.Rules
+		}
+
+		refunded, refund, err := api.Database.RefundReceipt(receipt.GetId(), request.Items, request.Reason, rules)
+
+		if err != nil {
+			return receiptStatusErrorResponse(err, "error while refunding receipt %s", receipt.GetId())
+		}
+
+		return 200, gin.H{
+			"id":     refunded.GetId(),
+			"status": refunded.Status,
+			"points": *refunded.Points,
+			"refund": refund,
+		}
+	}
+
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		api.handleIdempotentRequest(c, key, process)
+		return
+	}
+
+	c.JSON(process(c))
+}
+
+// Get the response status code and body for an error returned while changing the status of a receipt
+func receiptStatusErrorResponse(err error, message string, args ...any) (int, gin.H) {
+	var closed *ReceiptStatusError
+	var invalid *RefundError
+
+	switch {
+	case errors.As(err, &closed):
+		return 409, gin.H{
+			"error":  fmt.Sprintf("The receipt is %s.", closed.Status),
+			"status": closed.Status,
+		}
+	case errors.As(err, &invalid):
+		return 400, gin.H{
+			"error": fmt.Sprintf("The refund is invalid. %s", invalid.Reason),
+		}
+	}
+
+	return pointsErrorResponse(err, message, args...)
+}
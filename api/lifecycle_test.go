@@ -0,0 +1,398 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Insert a copy of example receipt D for a customer, with the purchase time changed so that it isn't a duplicate. It earns 109
+// points for 4 items totalling 9.00.
+func insertCustomerGatoradeReceipt(t *testing.T, db *ReceiptDatabase, customerId string) *Receipt {
+	items := make([]ReceiptItem, 4)
+
+	for i := range items {
+		items[i] = ReceiptItem{ShortDescription: "Gatorade", Price: "2.25"}
+	}
+
+	receipt := &Receipt{
+		Retailer:      "M&M Corner Market",
+		PurchaseDate:  "2022-03-20",
+		PurchaseTime:  "14:34",
+		PurchaseTotal: "9.00",
+		Items:         items,
+		CustomerId:    customerId,
+	}
+
+	if _, err := db.InsertReceipt(receipt); err != nil {
+		t.Fatalf("unexpected error while inserting receipt. %s", err)
+	}
+
+	return receipt
+}
+
+func TestRefundReceipt(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	receipt := insertCustomerGatoradeReceipt(t, db, customer.Id)
+
+	if *receipt.Points != 109 || receipt.Status != ReceiptStatusActive || len(receipt.StatusHistory) != 1 {
+		t.Fatalf("expected an active receipt with 109 points, but received %v", receipt)
+	}
+
+	// without the last item, the total of 6.75 is no longer a round dollar amount and only one pair of items remains
+	refunded, refund, err := db.RefundReceipt(receipt.GetId(), []int{3}, "damaged", DefaultRuleSet())
+
+	if err != nil {
+		t.Fatalf("unexpected error while refunding receipt. %s", err)
+	}
+
+	if refunded.Status != ReceiptStatusPartiallyRefunded || *refunded.Points != 54 || refund.Amount != "2.25" || refund.PointsChange != -55 {
+		t.Errorf("expected a partial refund of 2.25 leaving 54 points, but received %v and %v", refunded, refund)
+	}
+
+	// the original items and total are kept for audit
+	if len(refunded.Items) != 4 || refunded.PurchaseTotal != "9.00" || *receipt.Points != 109 || len(receipt.Refunds) != 0 {
+		t.Errorf("expected the original receipt to be unchanged, but received %v", refunded)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != 54 {
+		t.Errorf("expected a balance of 54, but received %d", balance)
+	}
+
+	var invalid *RefundError
+
+	for _, items := range [][]int{{}, {3}, {4}, {-1}, {0, 0}} {
+		if _, _, err := db.RefundReceipt(receipt.GetId(), items, "", DefaultRuleSet()); !errors.As(err, &invalid) {
+			t.Errorf("expected refund error for items %v, but received %v", items, err)
+		}
+	}
+
+	// refunding the remaining items gives back every point
+	refunded, refund, err = db.RefundReceipt(receipt.GetId(), []int{0, 1, 2}, "", DefaultRuleSet())
+
+	if err != nil {
+		t.Fatalf("unexpected error while refunding receipt. %s", err)
+	}
+
+	if refunded.Status != ReceiptStatusRefunded || *refunded.Points != 0 || refund.PointsChange != -54 || len(refunded.StatusHistory) != 3 {
+		t.Errorf("expected a fully refunded receipt with no points, but received %v", refunded)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != 0 {
+		t.Errorf("expected a balance of 0, but received %d", balance)
+	}
+
+	var closed *ReceiptStatusError
+
+	if _, err := db.VoidReceipt(receipt.GetId(), ""); !errors.As(err, &closed) || closed.Status != ReceiptStatusRefunded {
+		t.Errorf("expected refunded receipt to not be voided, but received %v", err)
+	}
+
+	// closed receipts are never re-scored
+	if report, _ := db.RecomputePoints(true); report.Rescored != 4 {
+		t.Errorf("expected only the example receipts to be re-scored, but received %d", report.Rescored)
+	}
+}
+
+func TestRefundReceiptNeverAddsPoints(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	receipt := &Receipt{
+		Retailer:      "Target",
+		PurchaseDate:  "2022-01-02",
+		PurchaseTime:  "13:14",
+		PurchaseTotal: "2.10",
+		Items:         []ReceiptItem{{ShortDescription: "Pepsi - 12-oz", Price: "2.00"}, {ShortDescription: "Gum", Price: "0.10"}},
+		CustomerId:    customer.Id,
+	}
+
+	if _, err := db.InsertReceipt(receipt); err != nil {
+		t.Fatalf("unexpected error while inserting receipt. %s", err)
+	}
+
+	// without the gum, the total of 2.00 would earn round dollar and quarter points
+	refunded, refund, err := db.RefundReceipt(receipt.GetId(), []int{1}, "", DefaultRuleSet())
+
+	if err != nil {
+		t.Fatalf("unexpected error while refunding receipt. %s", err)
+	}
+
+	if refund.PointsChange > 0 || *refunded.Points > *receipt.Points || *refunded.BasePoints > *receipt.BasePoints {
+		t.Errorf("expected the refund to not add points to %d, but received %d", *receipt.Points, *refunded.Points)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance > *receipt.Points {
+		t.Errorf("expected a balance of at most %d, but received %d", *receipt.Points, balance)
+	}
+}
+
+func TestRescoreAfterRefund(t *testing.T) {
+	db := setupTestDatabase(t)
+	db.Tiers, _ = NewTierPolicy("bronze=0:1.5", TierBasisPoints, 24*time.Hour)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	receipt := &Receipt{
+		Retailer:      "Target",
+		PurchaseDate:  "2022-01-02",
+		PurchaseTime:  "13:14",
+		PurchaseTotal: "2.10",
+		Items:         []ReceiptItem{{ShortDescription: "Pepsi - 12-oz", Price: "2.00"}, {ShortDescription: "Gum", Price: "0.10"}},
+		CustomerId:    customer.Id,
+	}
+
+	db.InsertReceipt(receipt)
+	refunded, _, err := db.RefundReceipt(receipt.GetId(), []int{1}, "", DefaultRuleSet())
+
+	if err != nil {
+		t.Fatalf("unexpected error while refunding receipt. %s", err)
+	}
+
+	// re-scoring under the same rules keeps the tier the receipt was awarded and caps the points like the refund did, so they
+	// don't grow back
+	db.Tiers, _ = NewTierPolicy("bronze=0:1", TierBasisPoints, 24*time.Hour)
+	rules := DefaultRuleSet()
+	rules.Version = "2"
+
+	report, err := db.RescoreReceipts(rules, func(r *Receipt) bool { return r.GetId() == receipt.GetId() }, false)
+
+	if err != nil {
+		t.Fatalf("unexpected error while re-scoring receipts. %s", err)
+	}
+
+	if report.PointsChange != 0 {
+		t.Errorf("expected re-scoring to keep %d points, but received a change of %d", *refunded.Points, report.PointsChange)
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != *refunded.Points {
+		t.Errorf("expected a balance of %d, but received %d", *refunded.Points, balance)
+	}
+}
+
+func TestGetRemainingNeverSharesItems(t *testing.T) {
+	receipt := &Receipt{
+		PurchaseTotal: "2.10",
+		Items:         []ReceiptItem{{ShortDescription: "Pepsi - 12-oz", Price: "2.00"}, {ShortDescription: "Gum", Price: "0.10"}},
+	}
+
+	// scoring caches parsed prices on the items of the copy, so a receipt visible to readers must never share them
+	for _, refunds := range [][]ReceiptRefund{nil, {{Items: []int{1}}}} {
+		receipt.Refunds = refunds
+		remaining := receipt.GetRemaining()
+
+		if remaining == receipt || &remaining.Items[0] == &receipt.Items[0] {
+			t.Errorf("expected a copy with its own items for refunds %v", refunds)
+		}
+	}
+}
+
+func TestVoidReceipt(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	receipt := insertCustomerReceipt(t, db, customer.Id, "13:14")
+
+	voided, err := db.VoidReceipt(receipt.GetId(), "entered by mistake")
+
+	if err != nil {
+		t.Fatalf("unexpected error while voiding receipt. %s", err)
+	}
+
+	if voided.Status != ReceiptStatusVoided || *voided.Points != 0 || voided.StatusHistory[1].Reason != "entered by mistake" {
+		t.Errorf("expected a voided receipt with no points, but received %v", voided)
+	}
+
+	if voided.StatusHistory[0].Status != ReceiptStatusActive || voided.StatusHistory[0].Points != 31 {
+		t.Errorf("expected the history to keep the original 31 points, but received %v", voided.StatusHistory)
+	}
+
+	page, _ := db.GetLedger(customer.Id, "", DefaultQueryLimit)
+
+	if len(page.Entries) != 2 || page.Entries[1].Type != LedgerEntryReverse || page.Entries[1].Balance != 0 {
+		t.Errorf("expected the points to be reversed, but received %v", page.Entries)
+	}
+
+	var closed *ReceiptStatusError
+
+	if _, err := db.VoidReceipt(receipt.GetId(), ""); !errors.As(err, &closed) {
+		t.Errorf("expected error while voiding receipt twice, but received %v", err)
+	}
+
+	// receipts without a customer can be voided too
+	if voided, err := db.VoidReceipt(exampleIdA, ""); err != nil || voided.Status != ReceiptStatusVoided {
+		t.Errorf("unexpected error while voiding example receipt. %v", err)
+	}
+}
+
+func TestRefundReceiptRestore(t *testing.T) {
+	config := &Config{StorageBackend: StorageBackendFile, StoragePath: t.TempDir()}
+
+	db := SetupDatabase(config, DefaultRuleSet())
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	receipt := insertCustomerGatoradeReceipt(t, db, customer.Id)
+
+	db.RefundReceipt(receipt.GetId(), []int{3}, "", DefaultRuleSet())
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error while closing database. %s", err)
+	}
+
+	restored := SetupDatabase(config, DefaultRuleSet())
+	defer restored.Close()
+
+	// the refunded item stays refunded
+	if _, _, err := restored.RefundReceipt(receipt.GetId(), []int{3}, "", DefaultRuleSet()); err == nil {
+		t.Error("expected error while refunding a restored refund again, but received nil")
+	}
+
+	refunded, _, err := restored.RefundReceipt(receipt.GetId(), []int{1, 2}, "", DefaultRuleSet())
+
+	if err != nil || *refunded.Points != 49 || len(refunded.StatusHistory) != 3 {
+		t.Errorf("expected 49 points after a second partial refund, but received %v. %v", refunded, err)
+	}
+}
+
+func TestVoidedReceiptCannotBeRevived(t *testing.T) {
+	api := setupTestApi(t, nil)
+
+	customer, _ := api.Database.InsertCustomer(&CustomerInput{Name: "Ada"})
+	receipt := insertCustomerGatoradeReceipt(t, api.Database, customer.Id)
+
+	if status, response := performRequest(t, api, "POST", "/receipts/"+receipt.GetId()+"/void", ""); status != 200 {
+		t.Fatalf("unexpected error while voiding receipt. %v", response)
+	}
+
+	// submitting the voided receipt again, with its own id, is a duplicate of the voided receipt
+	body := fmt.Sprintf(`{"id": %q, "customerId": %q, "retailer": "M&M Corner Market", "purchaseDate": "2022-03-20",
+		"purchaseTime": "14:34", "total": "9.00", "status": "active", "items": [{"shortDescription": "Gatorade", "price": "2.25"},
+		{"shortDescription": "Gatorade", "price": "2.25"}, {"shortDescription": "Gatorade", "price": "2.25"},
+		{"shortDescription": "Gatorade", "price": "2.25"}]}`, receipt.GetId(), customer.Id)
+
+	if status, response := performRequest(t, api, "POST", "/receipts/process", body); status != 200 || response["id"] != receipt.GetId() {
+		t.Errorf("expected the id of the voided receipt, but received %d %v", status, response)
+	}
+
+	// inserting the voided receipt directly is rejected
+	revived := *receipt
+
+	var exists *ReceiptExistsError
+
+	if _, err := api.Database.InsertReceipt(&revived); !errors.As(err, &exists) {
+		t.Errorf("expected receipt exists error, but received %v", err)
+	}
+
+	voided, _ := api.Database.GetReceiptById(receipt.GetId())
+
+	if voided.Status != ReceiptStatusVoided || len(voided.StatusHistory) != 2 || *voided.Points != 0 {
+		t.Errorf("expected the receipt to stay voided with its history, but received %s %v", voided.Status, voided.StatusHistory)
+	}
+
+	if balance, _, _ := api.Database.GetCustomerBalance(customer.Id); balance != 0 {
+		t.Errorf("expected a balance of 0, but received %d", balance)
+	}
+}
+
+func TestRefundReceiptBreakdown(t *testing.T) {
+	api := setupTestApi(t, func(config *Config) {
+		config.LoyaltyTiers = "bronze=0:1.5"
+		config.LoyaltyTierBasis = TierBasisPoints
+		config.LoyaltyTierWindow = 24 * time.Hour
+	})
+
+	customer, _ := api.Database.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	body := `{"retailer": "Target", "purchaseDate": "2022-01-02", "purchaseTime": "13:14", "total": "2.10", "customerId": "` +
+		customer.Id + `", "items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.10"}, {"shortDescription": "Gum", "price": "1.00"}]}`
+
+	_, created := performRequest(t, api, "POST", "/receipts/process", body)
+	id := created["id"].(string)
+	original := mustGetReceipt(t, api.Database, id)
+
+	// the tier changes after the receipt was scored, but the refund keeps the tier the receipt was awarded
+	api.Database.Tiers, _ = NewTierPolicy("bronze=0:1", TierBasisPoints, 24*time.Hour)
+
+	// without the first item the total of 1.00 is a round dollar amount, which would add points without the cap
+	status, refunded := performRequest(t, api, "POST", "/receipts/"+id+"/refunds", `{"items": [0]}`)
+
+	if status != 200 || refunded["points"].(float64) > float64(*original.Points) {
+		t.Fatalf("expected the refund to not add points to %d, but received %d %v", *original.Points, status, refunded)
+	}
+
+	receipt := mustGetReceipt(t, api.Database, id)
+
+	status, breakdown := performRequest(t, api, "GET", "/receipts/"+id+"/points/breakdown", "")
+
+	if status != 200 || breakdown["points"] != float64(*receipt.Points) || breakdown["basePoints"] != float64(*receipt.BasePoints) {
+		t.Fatalf("expected the breakdown to match %d stored points, but received %d %v", *receipt.Points, status, breakdown)
+	}
+
+	if receipt.Tier == nil || receipt.Tier.Multiplier != "1.5" {
+		t.Errorf("expected the tier the receipt was awarded to be kept, but received %v", receipt.Tier)
+	}
+
+	results := breakdown["breakdown"].([]any)
+
+	if last := results[len(results)-1].(map[string]any); last["rule"] != RefundCapRule {
+		t.Errorf("expected the breakdown to end with the refund cap, but received %v", results)
+	}
+}
+
+// Get a stored receipt, failing the test if it doesn't exist
+func mustGetReceipt(t *testing.T, db *ReceiptDatabase, id string) *Receipt {
+	receipt, err := db.GetReceiptById(id)
+
+	if err != nil {
+		t.Fatalf("unexpected error while getting receipt %s. %s", id, err)
+	}
+
+	return receipt
+}
+
+func TestLifecycleEndpoints(t *testing.T) {
+	api := setupTestApi(t, nil)
+
+	customer, _ := api.Database.InsertCustomer(&CustomerInput{Name: "Ada"})
+	receipt := insertCustomerGatoradeReceipt(t, api.Database, customer.Id)
+	refunds := "/receipts/" + receipt.GetId() + "/refunds"
+
+	// each request runs in order against the same API, so later requests see the changes made by earlier ones
+	cases := []struct {
+		path   string
+		body   string
+		status int
+		error  string
+	}{
+		{"/receipts/" + exampleIdA + "/void", "", 200, ""},
+		{"/receipts/" + exampleIdA + "/void", "", 409, "The receipt is voided."},
+		{"/receipts/not-an-id/void", "", 400, "invalid receipt id format"},
+		{"/receipts/00000000-0000-0000-0000-000000000000/void", "", 404, "no receipt found"},
+		{"/receipts/" + exampleIdB + "/void", fmt.Sprintf(`{"reason": "%0501d"}`, 0), 400, "The void is invalid."},
+		{refunds, `{"items": []}`, 400, "The refund is invalid."},
+		{refunds, `{"items": [9]}`, 400, "The refund is invalid. item 9 does not exist"},
+		{refunds, `{"items": [3], "reason": "damaged"}`, 200, ""},
+		{refunds, `{"items": [3]}`, 400, "The refund is invalid. item 3 has already been refunded"},
+		{"/receipts/" + exampleIdA + "/refunds", `{"items": [0]}`, 409, "The receipt is voided."},
+	}
+
+	for _, test := range cases {
+		status, response := performRequest(t, api, "POST", test.path, test.body)
+
+		if status != test.status || (test.error != "" && response["error"] != test.error) {
+			t.Errorf("expected status %d and error %q from %s %s, but received %d with %v", test.status, test.error, test.path, test.body, status, response)
+		}
+	}
+
+	if status, response := performRequest(t, api, "GET", "/receipts/"+receipt.GetId(), ""); status != 200 ||
+		response["status"] != ReceiptStatusPartiallyRefunded || response["points"] != float64(54) {
+		t.Errorf("expected the refunded receipt to be partially refunded with 54 points, but received %d with %v", status, response)
+	}
+
+	if _, response := performRequest(t, api, "GET", "/receipts/"+exampleIdA, ""); response["status"] != ReceiptStatusVoided || response["points"] != float64(0) {
+		t.Errorf("expected receipt A to be voided with no points, but received %v", response)
+	}
+}
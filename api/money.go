@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// A fixed-point amount of money stored as a whole number of cents. Using cents rather than a float keeps comparisons such as
+// "multiple of 0.25" exact for every input.
+type Money int64
+
+const (
+	Cent    Money = 1
+	Quarter Money = 25
+	Dollar  Money = 100
+)
+
+// The largest number of whole dollars that can be represented without overflowing
+const maxMoneyDollars = (1<<63 - 1 - 99) / 100
+
+var moneyPattern = regexp.MustCompile(`^(\d+)\.(\d{2})$`)
+
+// Parse a money value from a string formatted with exactly two decimal places (eg. 35.35)
+func ParseMoney(value string) (Money, error) {
+	match := moneyPattern.FindStringSubmatch(value)
+
+	if match == nil {
+		return 0, fmt.Errorf("invalid money value %q, expected a value such as 1.25", value)
+	}
+
+	dollars, err := strconv.ParseInt(match[1], 10, 64)
+
+	if err != nil || dollars > maxMoneyDollars {
+		return 0, fmt.Errorf("money value %q is too large", value)
+	}
+
+	cents, err := strconv.ParseInt(match[2], 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("error while parsing cents from money value %q. %s", value, err)
+	}
+
+	return Money(dollars*100 + cents), nil
+}
+
+// Format the money value with exactly two decimal places
+func (m Money) String() string {
+	sign := ""
+	value := int64(m)
+
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, value/100, value%100)
+}
+
+// Get the value as a whole number of cents
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Add two values, returning an error rather than overflowing
+func (m Money) Add(other Money) (Money, error) {
+	sum := m + other
+
+	if (other > 0 && sum < m) || (other < 0 && sum > m) {
+		return 0, fmt.Errorf("overflow while adding money values %s and %s", m, other)
+	}
+
+	return sum, nil
+}
+
+// Check if the value is an exact multiple of the given unit (eg. Dollar or Quarter)
+func (m Money) IsMultipleOf(unit Money) bool {
+	if unit == 0 {
+		return false
+	}
+
+	return m%unit == 0
+}
+
+// Multiply the value by numerator/denominator and round up to the nearest whole dollar. For example, 12.25 * 1/5 = 2.45
+// which rounds up to 3.
+func (m Money) MultiplyAndRoundUp(numerator int64, denominator int64) (int64, error) {
+	if denominator <= 0 {
+		return 0, errors.New("denominator must be greater than zero")
+	}
+
+	product := int64(m) * numerator
+
+	if numerator != 0 && product/numerator != int64(m) {
+		return 0, fmt.Errorf("overflow while multiplying money value %s", m)
+	}
+
+	divisor := denominator * 100
+	quotient := product / divisor
+
+	// integer division truncates toward zero, so only positive remainders need to be rounded up
+	if product%divisor > 0 {
+		quotient++
+	}
+
+	return quotient, nil
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	valid := map[string]Money{
+		"0.00":  0,
+		"0.01":  1,
+		"1.25":  125,
+		"35.35": 3535,
+		"12.00": 1200,
+	}
+
+	for value, expected := range valid {
+		money, err := ParseMoney(value)
+
+		if err != nil {
+			t.Errorf("unexpected error while parsing money value %s. %s", value, err)
+		}
+
+		if money != expected {
+			t.Errorf("expected %s to parse to %d cents, but received %d instead", value, expected, money)
+		}
+
+		if money.String() != value {
+			t.Errorf("expected %s to format as %s, but received %s instead", value, value, money.String())
+		}
+	}
+
+	invalid := []string{"", "1", "1.2", "1.234", "-1.00", " 1.00", "1,00", "abc", "99999999999999999999.00"}
+
+	for _, value := range invalid {
+		if _, err := ParseMoney(value); err == nil {
+			t.Errorf("expected error while parsing money value %q, but received nil", value)
+		}
+	}
+}
+
+func TestMoneyIsMultipleOf(t *testing.T) {
+	if !Money(900).IsMultipleOf(Dollar) || Money(925).IsMultipleOf(Dollar) {
+		t.Errorf("expected only whole dollar amounts to be a multiple of a dollar")
+	}
+
+	// 0.30 and 0.70 are the classic floating point failures for math.Mod(x, 0.25)
+	for _, cents := range []Money{0, 25, 50, 75, 100, 1075, 123456775} {
+		if !cents.IsMultipleOf(Quarter) {
+			t.Errorf("expected %s to be a multiple of 0.25", cents)
+		}
+	}
+
+	for _, cents := range []Money{1, 30, 70, 99, 1001} {
+		if cents.IsMultipleOf(Quarter) {
+			t.Errorf("expected %s to not be a multiple of 0.25", cents)
+		}
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	if sum, err := Money(125).Add(250); err != nil || sum != 375 {
+		t.Errorf("expected 1.25 + 2.50 to be 3.75, but received %s. %v", sum, err)
+	}
+
+	if _, err := Money(1 << 62).Add(1 << 62); err == nil {
+		t.Errorf("expected overflow error while adding very large money values, but received nil")
+	}
+}
+
+func TestMoneyMultiplyAndRoundUp(t *testing.T) {
+	cases := map[Money]int64{
+		0:    0,
+		1:    1,
+		500:  1,
+		501:  2,
+		1200: 3,
+		1225: 3,
+		1500: 3,
+		2500: 5,
+	}
+
+	for money, expected := range cases {
+		points, err := money.MultiplyAndRoundUp(1, 5)
+
+		if err != nil {
+			t.Errorf("unexpected error while multiplying %s. %s", money, err)
+		}
+
+		if points != expected {
+			t.Errorf("expected ceil(%s*0.2) to be %d, but received %d instead", money, expected, points)
+		}
+	}
+
+	if _, err := Money(1<<62).MultiplyAndRoundUp(4, 1); err == nil {
+		t.Errorf("expected overflow error while multiplying a very large money value, but received nil")
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	SortByPurchaseDatetime = "purchaseDatetime"
+	SortByPoints           = "points"
+	SortByTotal            = "total"
+)
+
+const (
+	DefaultQueryLimit = 50
+	MaxQueryLimit     = 500
+)
+
+// The options used to page through, sort and filter receipts
+type ReceiptQuery struct {
+	Limit      int
+	Cursor     string
+	SortBy     string
+	Descending bool
+
+	// optional filters, all of which must match for a receipt to be returned
+	Retailer         string
+	PurchaseDateFrom string
+	PurchaseDateTo   string
+	MinTotal         *Money
+	MaxTotal         *Money
+	MinPoints        *int
+}
+
+// A single page of receipts. NextCursor is empty when there are no more receipts to return.
+type ReceiptPage struct {
+	Receipts   []*Receipt `json:"receipts"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// The names of the sort indexes for each sort field, with and without a retailer prefix
+var receiptSortIndexes = map[string][2]string{
+	SortByPurchaseDatetime: {"purchase_datetime", "retailer_purchase_datetime"},
+	SortByPoints:           {"points", "retailer_points"},
+	SortByTotal:            {"total", "retailer_total"},
+}
+
+// An index over a sort key derived from each receipt, optionally prefixed with the normalized retailer name. Keys are suffixed
+// with the receipt id so that every key is unique and pagination is stable when several receipts share the same sort value.
+type receiptSortIndex struct {
+	key      func(receipt *Receipt) ([]byte, error)
+	retailer bool
+}
+
+func (index *receiptSortIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	receipt, ok := obj.(*Receipt)
+
+	if !ok {
+		return false, nil, fmt.Errorf("unable to index object of type %T", obj)
+	}
+
+	return index.keyFor(receipt)
+}
+
+// Accepts a single, already encoded key (or key prefix) so that callers can seek directly to a position in the index
+func (index *receiptSortIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("must provide exactly one argument")
+	}
+
+	key, ok := args[0].([]byte)
+
+	if !ok {
+		return nil, fmt.Errorf("argument must be a []byte: %#v", args[0])
+	}
+
+	return key, nil
+}
+
+func (index *receiptSortIndex) keyFor(receipt *Receipt) (bool, []byte, error) {
+	if receipt.Id == nil {
+		return false, nil, nil
+	}
+
+	value, err := index.key(receipt)
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	key := make([]byte, 0)
+
+	if index.retailer {
+		key = append(key, retailerKey(receipt.Retailer)...)
+	}
+
+	key = append(key, value...)
+	key = append(key, *receipt.Id...)
+	key = append(key, 0)
+
+	return true, key, nil
+}
+
+// An index over the receipt content fingerprint, used to find duplicate receipts
+type receiptFingerprintIndex struct{}
+
+func (index *receiptFingerprintIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	receipt, ok := obj.(*Receipt)
+
+	if !ok {
+		return false, nil, fmt.Errorf("unable to index object of type %T", obj)
+	}
+
+	fingerprint, err := receipt.GetFingerprint()
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	return true, []byte(fingerprint + "\x00"), nil
+}
+
+func (index *receiptFingerprintIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("must provide exactly one argument")
+	}
+
+	fingerprint, ok := args[0].(string)
+
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+
+	return []byte(fingerprint + "\x00"), nil
+}
+
+// Receipts are ordered by the purchase date and time as written on the receipt
+func purchaseDatetimeKey(receipt *Receipt) ([]byte, error) {
+	return []byte(receipt.PurchaseDate + "T" + receipt.PurchaseTime + "\x00"), nil
+}
+
+// Get the sort key for the points stored on a receipt
+func storedPointsKey(receipt *Receipt) ([]byte, error) {
+	if receipt.Points == nil {
+		return nil, fmt.Errorf("receipt %s has not been scored", receipt.GetId())
+	}
+
+	return pointsKey(*receipt.Points), nil
+}
+
+// Get the sort key for the purchase total of a receipt
+func purchaseTotalKey(receipt *Receipt) ([]byte, error) {
+	total, err := receipt.GetPurchaseTotal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return totalKey(total), nil
+}
+
+// Encode a total so that the byte order of the keys matches the numeric order of the totals
+func totalKey(total Money) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(total)^(1<<63))
+
+	return key
+}
+
+// Encode points so that the byte order of the keys matches the numeric order of the points
+func pointsKey(points int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(points)^(1<<63))
+
+	return key
+}
+
+// Retailer names are matched exactly, ignoring case and surrounding whitespace
+func retailerKey(retailer string) []byte {
+	return []byte(strings.ToLower(strings.TrimSpace(retailer)) + "\x00")
+}
+
+// Get the smallest key that sorts after every key beginning with the given prefix
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// Cursors are the index key of the last receipt on the previous page, tagged with the index name so that a cursor can't be
+// reused with a different sort order
+func encodeCursor(index string, key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(append([]byte(index+"\x00"), key...))
+}
+
+func decodeCursor(index string, cursor string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	prefix := []byte(index + "\x00")
+
+	if !bytes.HasPrefix(raw, prefix) {
+		return nil, errors.New("cursor does not match the requested sort order")
+	}
+
+	return raw[len(prefix):], nil
+}
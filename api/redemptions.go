@@ -0,0 +1,771 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-memdb"
+)
+
+// Hold statuses. A hold is active until it is captured, released, or expires.
+const (
+	HoldStatusActive   = "active"
+	HoldStatusCaptured = "captured"
+	HoldStatusReleased = "released"
+	HoldStatusExpired  = "expired"
+)
+
+// The longest a hold can be kept before it expires
+const MaxHoldDuration = 7 * 24 * time.Hour
+
+// Points set aside for a pending purchase. Held points can't be spent until the hold is captured, which spends them, or released,
+// which returns them to the balance. Holds that are not captured in time expire and are released automatically.
+type Hold struct {
+	Id         string    `json:"id"`
+	CustomerId string    `json:"customerId"`
+	Points     int       `json:"points"`
+	Status     string    `json:"status"`
+	Lots       []HoldLot `json:"lots"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// The points held from a single receipt
+type HoldLot struct {
+	ReceiptId string `json:"receiptId"`
+	Points    int    `json:"points"`
+}
+
+// The outcome of spending points
+type Redemption struct {
+	TransactionId string         `json:"transactionId"`
+	CustomerId    string         `json:"customerId"`
+	Points        int            `json:"points"`
+	Balance       int            `json:"balance"`
+	Entries       []*LedgerEntry `json:"entries"`
+}
+
+// Returned when a customer does not have enough spendable points
+type InsufficientPointsError struct {
+	Balance   int
+	Requested int
+}
+
+func (err *InsufficientPointsError) Error() string {
+	return fmt.Sprintf("insufficient points, %d requested but only %d available", err.Requested, err.Balance)
+}
+
+// Returned when a hold can no longer be captured or released
+type HoldNotActiveError struct {
+	Id     string
+	Status string
+}
+
+func (err *HoldNotActiveError) Error() string {
+	return fmt.Sprintf("hold %s is %s", err.Id, err.Status)
+}
+
+// Returned when the points of a receipt can't be reversed
+type ReversalError struct {
+	ReceiptId string
+	Reason    string
+}
+
+func (err *ReversalError) Error() string {
+	return fmt.Sprintf("unable to reverse points of receipt %s. %s", err.ReceiptId, err.Reason)
+}
+
+// Returned when a hold does not exist, or belongs to a different customer
+var ErrHoldNotFound = errors.New("hold not found")
+
+// Returned when spending or holding no points, or a negative number of points
+var ErrInvalidPoints = errors.New("points must be greater than zero")
+
+// Take points from the receipts of a customer, writing a ledger entry for each receipt. Points that expire soonest are taken
+// first, or points credited first when points never expire. Spent points are taken from the balance, while held points are
+// moved from the balance to the held points.
+func debitLots(txn *memdb.Txn, customerId string, points int, entryType string, transactionId string, policy ExpirationPolicy) ([]*LedgerEntry, []StorageRecord, error) {
+	last, err := lastLedgerEntry(txn, customerId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	balance := 0
+
+	if last != nil {
+		balance = last.Balance
+	}
+
+	if points > balance {
+		return nil, nil, &InsufficientPointsError{Balance: balance, Requested: points}
+	}
+
+	lots, err := customerLots(txn, customerId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := sortLotsByExpiry(txn, lots, policy); err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]*LedgerEntry, 0)
+	records := make([]StorageRecord, 0)
+
+	for _, lot := range lots {
+		if points == 0 {
+			break
+		}
+
+		if lot.Available <= 0 {
+			continue
+		}
+
+		take := min(lot.Available, points)
+		points -= take
+
+		entry := &LedgerEntry{
+			CustomerId:    customerId,
+			Type:          entryType,
+			Points:        -take,
+			ReceiptId:     lot.ReceiptId,
+			TransactionId: transactionId,
+		}
+
+		if entryType == LedgerEntryHold {
+			entry.HeldPoints = take
+		}
+
+		record, err := appendLedgerEntry(txn, entry)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entries = append(entries, entry)
+		records = append(records, record)
+	}
+
+	// a reversed receipt can leave the balance lower than the points remaining on the other receipts, but never higher
+	if points > 0 {
+		return nil, nil, fmt.Errorf("ledger for customer %s does not match its balance", customerId)
+	}
+
+	return entries, records, nil
+}
+
+// Finish an active hold, either spending the held points or returning them to the balance
+func resolveHold(txn *memdb.Txn, hold *Hold, status string, now time.Time) ([]StorageRecord, error) {
+	entryType := LedgerEntryRelease
+
+	if status == HoldStatusCaptured {
+		entryType = LedgerEntryCapture
+	}
+
+	records := make([]StorageRecord, 0, len(hold.Lots)+1)
+
+	for _, lot := range hold.Lots {
+		entry := &LedgerEntry{
+			CustomerId:    hold.CustomerId,
+			Type:          entryType,
+			HeldPoints:    -lot.Points,
+			ReceiptId:     lot.ReceiptId,
+			TransactionId: hold.Id,
+		}
+
+		if status != HoldStatusCaptured {
+			entry.Points = lot.Points
+		}
+
+		record, err := appendLedgerEntry(txn, entry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	// an updated copy replaces the original
+	updated := *hold
+	updated.Status = status
+	updated.UpdatedAt = now
+
+	if err := txn.Insert("hold", &updated); err != nil {
+		return nil, fmt.Errorf("unable to update hold %s. %s", hold.Id, err)
+	}
+
+	return append(records, StorageRecord{Type: StorageRecordHold, Hold: &updated}), nil
+}
+
+// Release every active hold that has expired, for a single customer or for every customer when no customer id is given
+func releaseExpiredHolds(txn *memdb.Txn, customerId string, now time.Time) ([]StorageRecord, error) {
+	it, err := txn.Get("hold", "status", HoldStatusActive)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying active holds. %s", err)
+	}
+
+	// collect the holds first, since the index can't be modified while it is being iterated
+	expired := make([]*Hold, 0)
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		hold := raw.(*Hold)
+
+		if (customerId == "" || hold.CustomerId == customerId) && !now.Before(hold.ExpiresAt) {
+			expired = append(expired, hold)
+		}
+	}
+
+	records := make([]StorageRecord, 0)
+
+	for _, hold := range expired {
+		released, err := resolveHold(txn, hold, HoldStatusExpired, now)
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, released...)
+	}
+
+	return records, nil
+}
+
+// Run a change to the points of a customer in a single write transaction. Expired holds of the customer are released first, so
+// that the points they held can be spent, and then expired points are taken away, so that they can't be. Every record returned
+// by the change is persisted before the transaction is committed.
+func (db ReceiptDatabase) updatePoints(customerId string, change func(txn *memdb.Txn, now time.Time) ([]StorageRecord, error)) error {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	now := time.Now().UTC()
+
+	records, err := releaseExpiredHolds(txn, customerId, now)
+
+	if err != nil {
+		return err
+	}
+
+	expired, err := expirePoints(txn, customerId, db.Expiration, now)
+
+	if err != nil {
+		return err
+	}
+
+	records = append(records, expired...)
+
+	changed, err := change(txn, now)
+
+	if err != nil {
+		return err
+	}
+
+	if err := db.Storage.Append(append(records, changed...)...); err != nil {
+		return fmt.Errorf("unable to persist points for customer %s. %s", customerId, err)
+	}
+
+	txn.Commit()
+
+	return nil
+}
+
+// Spend points from the balance of a customer
+func (db ReceiptDatabase) RedeemPoints(customerId string, points int) (*Redemption, error) {
+	if points <= 0 {
+		return nil, ErrInvalidPoints
+	}
+
+	redemption := &Redemption{
+		TransactionId: uuid.New().String(),
+		CustomerId:    customerId,
+		Points:        points,
+	}
+
+	err := db.updatePoints(customerId, func(txn *memdb.Txn, now time.Time) ([]StorageRecord, error) {
+		entries, records, err := debitLots(txn, customerId, points, LedgerEntryRedeem, redemption.TransactionId, db.Expiration)
+
+		if err != nil {
+			return nil, err
+		}
+
+		redemption.Entries = entries
+
+		if len(entries) > 0 {
+			redemption.Balance = entries[len(entries)-1].Balance
+		}
+
+		return records, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return redemption, nil
+}
+
+// Set aside points from the balance of a customer until the hold is captured, released, or expires
+func (db ReceiptDatabase) PlaceHold(customerId string, points int, duration time.Duration) (*Hold, error) {
+	if points <= 0 {
+		return nil, ErrInvalidPoints
+	}
+
+	hold := &Hold{
+		Id:         uuid.New().String(),
+		CustomerId: customerId,
+		Points:     points,
+		Status:     HoldStatusActive,
+		Lots:       make([]HoldLot, 0),
+	}
+
+	err := db.updatePoints(customerId, func(txn *memdb.Txn, now time.Time) ([]StorageRecord, error) {
+		entries, records, err := debitLots(txn, customerId, points, LedgerEntryHold, hold.Id, db.Expiration)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			hold.Lots = append(hold.Lots, HoldLot{ReceiptId: entry.ReceiptId, Points: entry.HeldPoints})
+		}
+
+		hold.CreatedAt = now
+		hold.UpdatedAt = now
+		hold.ExpiresAt = now.Add(duration)
+
+		if err := txn.Insert("hold", hold); err != nil {
+			return nil, fmt.Errorf("unable to insert hold. %s", err)
+		}
+
+		return append(records, StorageRecord{Type: StorageRecordHold, Hold: hold}), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// Capture or release an active hold of a customer
+func (db ReceiptDatabase) ResolveHold(customerId string, holdId string, status string) (*Hold, error) {
+	var resolved *Hold
+
+	err := db.updatePoints(customerId, func(txn *memdb.Txn, now time.Time) ([]StorageRecord, error) {
+		raw, err := txn.First("hold", "id", holdId)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while querying database for hold %s. %s", holdId, err)
+		}
+
+		if raw == nil || raw.(*Hold).CustomerId != customerId {
+			return nil, ErrHoldNotFound
+		}
+
+		hold := raw.(*Hold)
+
+		if hold.Status != HoldStatusActive {
+			return nil, &HoldNotActiveError{Id: hold.Id, Status: hold.Status}
+		}
+
+		records, err := resolveHold(txn, hold, status, now)
+
+		if err != nil {
+			return nil, err
+		}
+
+		resolved = records[len(records)-1].Hold
+
+		return records, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+// Get a hold of a customer by ID
+func (db ReceiptDatabase) GetHold(customerId string, holdId string) (*Hold, error) {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	raw, err := txn.First("hold", "id", holdId)
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying database for hold %s. %s", holdId, err)
+	}
+
+	if raw == nil || raw.(*Hold).CustomerId != customerId {
+		return nil, ErrHoldNotFound
+	}
+
+	return raw.(*Hold), nil
+}
+
+// Release every expired hold, returning the number of holds released
+func (db ReceiptDatabase) ReleaseExpiredHolds(now time.Time) (int, error) {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+
+	txn := db.MemDB.Txn(true)
+	defer txn.Abort()
+
+	records, err := releaseExpiredHolds(txn, "", now)
+
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	if err := db.Storage.Append(records...); err != nil {
+		return 0, fmt.Errorf("unable to persist expired holds. %s", err)
+	}
+
+	txn.Commit()
+
+	released := 0
+
+	for _, record := range records {
+		if record.Type == StorageRecordHold {
+			released++
+		}
+	}
+
+	return released, nil
+}
+
+// Take back the points a receipt earned for its customer, including any adjustments, within a write transaction. Points that
+// were already spent or held are still taken back, so the balance of the customer may become negative, while points that
+// already expired are not taken back twice.
+func reverseReceiptPoints(txn *memdb.Txn, receipt *Receipt) (*LedgerEntry, StorageRecord, error) {
+	if receipt.CustomerId == "" {
+		return nil, StorageRecord{}, &ReversalError{ReceiptId: receipt.GetId(), Reason: "the receipt has no customer"}
+	}
+
+	earned, reversed, err := receiptEarnings(txn, receipt)
+
+	if err != nil {
+		return nil, StorageRecord{}, err
+	}
+
+	if reversed {
+		return nil, StorageRecord{}, &ReversalError{ReceiptId: receipt.GetId(), Reason: "the points have already been reversed"}
+	}
+
+	entry := &LedgerEntry{
+		CustomerId: receipt.CustomerId,
+		Type:       LedgerEntryReverse,
+		Points:     -earned,
+		ReceiptId:  receipt.GetId(),
+	}
+
+	record, err := appendLedgerEntry(txn, entry)
+
+	if err != nil {
+		return nil, StorageRecord{}, err
+	}
+
+	return entry, record, nil
+}
+
+// Take back the points a receipt earned for its customer. The receipt is voided in the same transaction, so that a later void
+// or refund can't take back the same points again.
+func (db ReceiptDatabase) ReverseReceiptPoints(receiptId string) (*LedgerEntry, error) {
+	var reversal *LedgerEntry
+
+	_, err := db.updateReceipt(receiptId, func(txn *memdb.Txn, updated *Receipt, now time.Time) ([]StorageRecord, error) {
+		entry, record, err := reverseReceiptPoints(txn, updated)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reversal = entry
+
+		updated.Points = new(int)
+		updated.setStatus(ReceiptStatusVoided, "points reversed", now)
+
+		return []StorageRecord{record}, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reversal, nil
+}
+
+// Release expired holds and expire points on a fixed interval until the database is closed
+func (db ReceiptDatabase) sweepPeriodically(interval time.Duration) {
+	defer db.workers.Done()
+
+	if interval <= 0 {
+		<-db.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if released, err := db.ReleaseExpiredHolds(time.Now().UTC()); err != nil {
+				log.Printf("error while releasing expired holds. %s", err)
+			} else if released > 0 {
+				log.Printf("released %d expired holds", released)
+			}
+
+			if expired, err := db.ExpirePoints(time.Now().UTC()); err != nil {
+				log.Printf("error while expiring points. %s", err)
+			} else if expired > 0 {
+				log.Printf("expired %d points", expired)
+			}
+
+			if purged, err := db.PurgeExpiredIdempotencyKeys(time.Now()); err != nil {
+				log.Printf("error while purging expired idempotency keys. %s", err)
+			} else if purged > 0 {
+				log.Printf("purged %d expired idempotency keys", purged)
+			}
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+type PointsRequest struct {
+	Points int `json:"points" binding:"required,min=1"`
+}
+
+type HoldRequest struct {
+	Points int `json:"points" binding:"required,min=1"`
+
+	// how long the points are held before the hold expires, which defaults to the configured hold duration
+	ExpiresInSeconds int `json:"expiresInSeconds" binding:"omitempty,min=1"`
+}
+
+// Spend points from the balance of a customer
+// POST /customers/{id}/redemptions
+func (api ReceiptsApi) HandleCreateRedemption(c *gin.Context) {
+	customer, found := api.lookupCustomer(c)
+
+	if !found {
+		return
+	}
+
+	// process each request at most once per idempotency key
+	process := func(c *gin.Context) (int, gin.H) {
+		var request PointsRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return 400, gin.H{
+				"error":   "The redemption is invalid.",
+				"details": bindingValidationErrors(err),
+			}
+		}
+
+		redemption, err := api.Database.RedeemPoints(customer.Id, request.Points)
+
+		if err != nil {
+			return pointsErrorResponse(err, "error while redeeming points for customer %s", customer.Id)
+		}
+
+		return 200, gin.H{
+			"transactionId": redemption.TransactionId,
+			"customerId":    redemption.CustomerId,
+			"points":        redemption.Points,
+			"balance":       redemption.Balance,
+			"entries":       redemption.Entries,
+		}
+	}
+
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		api.handleIdempotentRequest(c, key, process)
+		return
+	}
+
+	c.JSON(process(c))
+}
+
+// Set aside points from the balance of a customer
+// POST /customers/{id}/holds
+func (api ReceiptsApi) HandleCreateHold(c *gin.Context) {
+	customer, found := api.lookupCustomer(c)
+
+	if !found {
+		return
+	}
+
+	process := func(c *gin.Context) (int, gin.H) {
+		var request HoldRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return 400, gin.H{
+				"error":   "The hold is invalid.",
+				"details": bindingValidationErrors(err),
+			}
+		}
+
+		tooLong := gin.H{
+			"error": fmt.Sprintf("The hold is invalid. holds can't be kept for longer than %s", MaxHoldDuration),
+		}
+
+		duration := api.Config.HoldDuration
+
+		if request.ExpiresInSeconds > 0 {
+			// seconds are checked before converting them, since a large number of seconds overflows a duration
+			if request.ExpiresInSeconds > int(MaxHoldDuration/time.Second) {
+				return 400, tooLong
+			}
+
+			duration = time.Duration(request.ExpiresInSeconds) * time.Second
+		}
+
+		if duration > MaxHoldDuration {
+			return 400, tooLong
+		}
+
+		hold, err := api.Database.PlaceHold(customer.Id, request.Points, duration)
+
+		if err != nil {
+			return pointsErrorResponse(err, "error while placing hold for customer %s", customer.Id)
+		}
+
+		return 200, gin.H{
+			"hold": hold,
+		}
+	}
+
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		api.handleIdempotentRequest(c, key, process)
+		return
+	}
+
+	c.JSON(process(c))
+}
+
+// Query a single hold of a customer
+// GET /customers/{id}/holds/{holdId}
+func (api ReceiptsApi) HandleGetHold(c *gin.Context) {
+	customer, found := api.lookupCustomer(c)
+
+	if !found {
+		return
+	}
+
+	hold, err := api.Database.GetHold(customer.Id, c.Param("holdId"))
+
+	if err != nil {
+		c.JSON(pointsErrorResponse(err, "error while querying hold for customer %s", customer.Id))
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"hold": hold,
+	})
+}
+
+// Spend the points set aside by an active hold
+// POST /customers/{id}/holds/{holdId}/capture
+func (api ReceiptsApi) HandleCaptureHold(c *gin.Context) {
+	api.handleResolveHold(c, HoldStatusCaptured)
+}
+
+// Return the points set aside by an active hold to the balance
+// POST /customers/{id}/holds/{holdId}/release
+func (api ReceiptsApi) HandleReleaseHold(c *gin.Context) {
+	api.handleResolveHold(c, HoldStatusReleased)
+}
+
+func (api ReceiptsApi) handleResolveHold(c *gin.Context, status string) {
+	customer, found := api.lookupCustomer(c)
+
+	if !found {
+		return
+	}
+
+	hold, err := api.Database.ResolveHold(customer.Id, c.Param("holdId"), status)
+
+	if err != nil {
+		c.JSON(pointsErrorResponse(err, "error while resolving hold for customer %s", customer.Id))
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"hold": hold,
+	})
+}
+
+// Take back the points a receipt earned for its customer
+// POST /admin/receipts/{id}/reverse
+func (api ReceiptsApi) HandleReverseReceiptPoints(c *gin.Context) {
+	receipt, found := api.lookupReceipt(c)
+
+	if !found {
+		return
+	}
+
+	entry, err := api.Database.ReverseReceiptPoints(receipt.GetId())
+
+	if err != nil {
+		c.JSON(receiptStatusErrorResponse(err, "error while reversing points for receipt %s", receipt.GetId()))
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"id":     receipt.GetId(),
+		"status": ReceiptStatusVoided,
+		"entry":  entry,
+	})
+}
+
+// Get the response status code and body for an error returned while changing the points of a customer
+func pointsErrorResponse(err error, message string, args ...any) (int, gin.H) {
+	var insufficient *InsufficientPointsError
+	var notActive *HoldNotActiveError
+	var reversal *ReversalError
+
+	switch {
+	case errors.As(err, &insufficient):
+		return 409, gin.H{
+			"error":     "Insufficient points.",
+			"balance":   insufficient.Balance,
+			"requested": insufficient.Requested,
+		}
+	case errors.As(err, &notActive):
+		return 409, gin.H{
+			"error":  fmt.Sprintf("The hold is %s.", notActive.Status),
+			"status": notActive.Status,
+		}
+	case errors.As(err, &reversal):
+		return 409, gin.H{
+			"error": fmt.Sprintf("The points can't be reversed. %s", reversal.Reason),
+		}
+	case errors.Is(err, ErrHoldNotFound):
+		return 404, gin.H{
+			"error": "no hold found",
+		}
+	case errors.Is(err, ErrInvalidPoints):
+		return 400, gin.H{
+			"error": "The points are invalid. points must be greater than zero",
+		}
+	}
+
+	log.Printf("%s. %s", fmt.Sprintf(message, args...), err)
+
+	return 500, gin.H{
+		"error": "unknown error",
+	}
+}
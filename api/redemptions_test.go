@@ -0,0 +1,316 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedeemPoints(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	first := insertCustomerReceipt(t, db, customer.Id, "13:14")
+	second := insertCustomerReceipt(t, db, customer.Id, "09:13")
+
+	// points are spent from the oldest receipt first, splitting the redemption across receipts when needed
+	redemption, err := db.RedeemPoints(customer.Id, 40)
+
+	if err != nil {
+		t.Fatalf("unexpected error while redeeming points. %s", err)
+	}
+
+	if redemption.Balance != 22 || len(redemption.Entries) != 2 {
+		t.Fatalf("expected two entries leaving a balance of 22, but received %v", redemption)
+	}
+
+	if redemption.Entries[0].ReceiptId != first.GetId() || redemption.Entries[0].Points != -31 {
+		t.Errorf("expected 31 points from the first receipt, but received %v", redemption.Entries[0])
+	}
+
+	if redemption.Entries[1].ReceiptId != second.GetId() || redemption.Entries[1].Points != -9 {
+		t.Errorf("expected 9 points from the second receipt, but received %v", redemption.Entries[1])
+	}
+
+	if redemption.Entries[0].TransactionId != redemption.TransactionId || redemption.Entries[1].TransactionId != redemption.TransactionId {
+		t.Errorf("expected entries to reference transaction %s", redemption.TransactionId)
+	}
+
+	// overdrafts are rejected without writing any entries
+	var insufficient *InsufficientPointsError
+
+	if _, err := db.RedeemPoints(customer.Id, 23); !errors.As(err, &insufficient) || insufficient.Balance != 22 {
+		t.Errorf("expected insufficient points error with a balance of 22, but received %v", err)
+	}
+
+	// spending or holding no points is rejected rather than writing an empty transaction
+	for _, points := range []int{0, -1} {
+		if _, err := db.RedeemPoints(customer.Id, points); !errors.Is(err, ErrInvalidPoints) {
+			t.Errorf("expected invalid points error while redeeming %d points, but received %v", points, err)
+		}
+
+		if _, err := db.PlaceHold(customer.Id, points, time.Hour); !errors.Is(err, ErrInvalidPoints) {
+			t.Errorf("expected invalid points error while holding %d points, but received %v", points, err)
+		}
+	}
+
+	if page, _ := db.GetLedger(customer.Id, "", DefaultQueryLimit); len(page.Entries) != 4 {
+		t.Errorf("expected 4 ledger entries, but received %d", len(page.Entries))
+	}
+}
+
+func TestHolds(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	other, _ := db.InsertCustomer(&CustomerInput{Name: "Grace"})
+
+	insertCustomerReceipt(t, db, customer.Id, "13:14")
+	insertCustomerReceipt(t, db, customer.Id, "09:13")
+
+	captured, err := db.PlaceHold(customer.Id, 20, time.Hour)
+
+	if err != nil {
+		t.Fatalf("unexpected error while placing hold. %s", err)
+	}
+
+	released, _ := db.PlaceHold(customer.Id, 30, time.Hour)
+
+	if balance, held, _ := db.GetCustomerBalance(customer.Id); balance != 12 || held != 50 {
+		t.Errorf("expected a balance of 12 with 50 held, but received %d and %d", balance, held)
+	}
+
+	if len(released.Lots) != 2 || released.Lots[0].Points != 11 || released.Lots[1].Points != 19 {
+		t.Errorf("expected the hold to span both receipts, but received %v", released.Lots)
+	}
+
+	// held points can't be spent
+	if _, err := db.RedeemPoints(customer.Id, 13); err == nil {
+		t.Error("expected error while redeeming held points, but received nil")
+	}
+
+	if _, err := db.ResolveHold(other.Id, captured.Id, HoldStatusCaptured); !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("expected hold of another customer to not be found, but received %v", err)
+	}
+
+	if _, err := db.ResolveHold(customer.Id, captured.Id, HoldStatusCaptured); err != nil {
+		t.Fatalf("unexpected error while capturing hold. %s", err)
+	}
+
+	if _, err := db.ResolveHold(customer.Id, released.Id, HoldStatusReleased); err != nil {
+		t.Fatalf("unexpected error while releasing hold. %s", err)
+	}
+
+	if balance, held, _ := db.GetCustomerBalance(customer.Id); balance != 42 || held != 0 {
+		t.Errorf("expected a balance of 42 with nothing held, but received %d and %d", balance, held)
+	}
+
+	// holds can only be resolved once
+	var notActive *HoldNotActiveError
+
+	if _, err := db.ResolveHold(customer.Id, captured.Id, HoldStatusReleased); !errors.As(err, &notActive) || notActive.Status != HoldStatusCaptured {
+		t.Errorf("expected captured hold to not be active, but received %v", err)
+	}
+
+	if hold, _ := db.GetHold(customer.Id, captured.Id); hold.Status != HoldStatusCaptured || captured.Status != HoldStatusActive {
+		t.Errorf("expected the stored hold to be replaced with a captured copy, but received %s", hold.Status)
+	}
+}
+
+func TestExpiredHolds(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	insertCustomerReceipt(t, db, customer.Id, "13:14")
+
+	hold, _ := db.PlaceHold(customer.Id, 31, time.Hour)
+
+	if released, err := db.ReleaseExpiredHolds(time.Now()); err != nil || released != 0 {
+		t.Errorf("expected no holds to be released before they expire, but received %d. %v", released, err)
+	}
+
+	if released, err := db.ReleaseExpiredHolds(time.Now().Add(2 * time.Hour)); err != nil || released != 1 {
+		t.Errorf("expected the hold to be released once it expires, but received %d. %v", released, err)
+	}
+
+	if balance, held, _ := db.GetCustomerBalance(customer.Id); balance != 31 || held != 0 {
+		t.Errorf("expected a balance of 31 with nothing held, but received %d and %d", balance, held)
+	}
+
+	var notActive *HoldNotActiveError
+
+	if _, err := db.ResolveHold(customer.Id, hold.Id, HoldStatusCaptured); !errors.As(err, &notActive) || notActive.Status != HoldStatusExpired {
+		t.Errorf("expected expired hold to not be captured, but received %v", err)
+	}
+
+	// expired holds are released before points are spent, even when the sweeper hasn't run yet
+	db.PlaceHold(customer.Id, 31, -time.Second)
+
+	if _, err := db.RedeemPoints(customer.Id, 31); err != nil {
+		t.Errorf("unexpected error while redeeming points of an expired hold. %s", err)
+	}
+}
+
+func TestReverseReceiptPoints(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	first := insertCustomerReceipt(t, db, customer.Id, "13:14")
+	insertCustomerReceipt(t, db, customer.Id, "09:13")
+
+	db.RedeemPoints(customer.Id, 40)
+
+	// the points are taken back even though some were already spent
+	entry, err := db.ReverseReceiptPoints(first.GetId())
+
+	if err != nil {
+		t.Fatalf("unexpected error while reversing points. %s", err)
+	}
+
+	if entry.Type != LedgerEntryReverse || entry.Points != -31 || entry.Balance != -9 || entry.ReceiptId != first.GetId() {
+		t.Errorf("expected a reversal of 31 points to a balance of -9, but received %v", entry)
+	}
+
+	// the receipt is voided along with the reversal, so its points can't be taken back again by a void or another reversal
+	if reversed, _ := db.GetReceiptById(first.GetId()); reversed.Status != ReceiptStatusVoided || *reversed.Points != 0 {
+		t.Errorf("expected a voided receipt without points, but received %s with %d points", reversed.Status, *reversed.Points)
+	}
+
+	var closed *ReceiptStatusError
+
+	if _, err := db.ReverseReceiptPoints(first.GetId()); !errors.As(err, &closed) {
+		t.Errorf("expected error while reversing points twice, but received %v", err)
+	}
+
+	if _, err := db.VoidReceipt(first.GetId(), ""); !errors.As(err, &closed) {
+		t.Errorf("expected error while voiding a reversed receipt, but received %v", err)
+	}
+
+	var reversal *ReversalError
+
+	if _, err := db.ReverseReceiptPoints(exampleIdA); !errors.As(err, &reversal) {
+		t.Errorf("expected error while reversing points of a receipt without a customer, but received %v", err)
+	}
+
+	// re-scoring a reversed receipt doesn't give back its points
+	rules, _ := NewRuleSet([]string{"retailer-name"})
+	rules.Version = "2"
+
+	db.RescoreReceipts(rules, func(receipt *Receipt) bool { return receipt.CustomerId == customer.Id }, false)
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != -34 {
+		t.Errorf("expected a balance of -34, but received %d", balance)
+	}
+}
+
+func TestHoldRestore(t *testing.T) {
+	config := &Config{StorageBackend: StorageBackendFile, StoragePath: t.TempDir()}
+
+	db := SetupDatabase(config, DefaultRuleSet())
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	insertCustomerReceipt(t, db, customer.Id, "13:14")
+
+	hold, _ := db.PlaceHold(customer.Id, 10, time.Hour)
+	db.ResolveHold(customer.Id, hold.Id, HoldStatusCaptured)
+	active, _ := db.PlaceHold(customer.Id, 5, time.Hour)
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error while closing database. %s", err)
+	}
+
+	restored := SetupDatabase(config, DefaultRuleSet())
+	defer restored.Close()
+
+	if restoredHold, err := restored.GetHold(customer.Id, hold.Id); err != nil || restoredHold.Status != HoldStatusCaptured {
+		t.Errorf("expected captured hold to be restored. %v", err)
+	}
+
+	if _, err := restored.ResolveHold(customer.Id, active.Id, HoldStatusReleased); err != nil {
+		t.Errorf("unexpected error while releasing restored hold. %s", err)
+	}
+
+	if balance, held, _ := restored.GetCustomerBalance(customer.Id); balance != 21 || held != 0 {
+		t.Errorf("expected a balance of 21 with nothing held, but received %d and %d", balance, held)
+	}
+}
+
+func TestHoldTooLong(t *testing.T) {
+	api := setupTestApi(t, func(config *Config) {
+		config.HoldDuration = time.Hour
+	})
+
+	customer, _ := api.Database.InsertCustomer(&CustomerInput{Name: "Ada"})
+	insertCustomerReceipt(t, api.Database, customer.Id, "13:14")
+
+	// 9223372037 seconds overflows a duration, which would otherwise wrap around to a hold that is already expired
+	for _, seconds := range []string{"604801", "9223372037"} {
+		status, response := performRequest(t, api, "POST", "/customers/"+customer.Id+"/holds", `{"points": 10, "expiresInSeconds": `+seconds+`}`)
+
+		if status != 400 {
+			t.Errorf("expected a hold of %s seconds to be rejected, but received %d %v", seconds, status, response)
+		}
+	}
+
+	if status, response := performRequest(t, api, "POST", "/customers/"+customer.Id+"/holds", `{"points": 10, "expiresInSeconds": 604800}`); status != 200 {
+		t.Errorf("expected a hold of 7 days to be placed, but received %d %v", status, response)
+	}
+}
+
+func TestRedemptionEndpoints(t *testing.T) {
+	api := setupTestApi(t, nil)
+
+	customer, _ := api.Database.InsertCustomer(&CustomerInput{Name: "Ada"})
+	insertCustomerGatoradeReceipt(t, api.Database, customer.Id)
+
+	redemptions := "/customers/" + customer.Id + "/redemptions"
+	holds := "/customers/" + customer.Id + "/holds"
+
+	// each request runs in order against the same API, so later requests see the changes made by earlier ones
+	cases := []struct {
+		path   string
+		body   string
+		status int
+		error  string
+	}{
+		{redemptions, `{"points": 0}`, 400, "The redemption is invalid."},
+		{redemptions, `{"points": -5}`, 400, "The redemption is invalid."},
+		{redemptions, `{"points": 110}`, 409, "Insufficient points."},
+		{"/customers/00000000-0000-0000-0000-000000000000/redemptions", `{"points": 10}`, 404, "no customer found"},
+		{redemptions, `{"points": 9}`, 200, ""},
+		{holds, `{"points": 0}`, 400, "The hold is invalid."},
+		{holds, `{"points": 10, "expiresInSeconds": 9999999999}`, 400, "The hold is invalid. holds can't be kept for longer than 168h0m0s"},
+		{holds, `{"points": 101}`, 409, "Insufficient points."},
+		{holds + "/00000000-0000-0000-0000-000000000000/capture", "", 404, "no hold found"},
+	}
+
+	for _, test := range cases {
+		status, response := performRequest(t, api, "POST", test.path, test.body)
+
+		if status != test.status || (test.error != "" && response["error"] != test.error) {
+			t.Errorf("expected status %d and error %q from %s %s, but received %d with %v", test.status, test.error, test.path, test.body, status, response)
+		}
+	}
+
+	status, response := performRequest(t, api, "POST", holds, `{"points": 40}`)
+
+	if status != 200 {
+		t.Fatalf("expected status 200 while placing a hold, but received %d with %v", status, response)
+	}
+
+	hold := response["hold"].(map[string]any)["id"].(string)
+
+	if status, response := performRequest(t, api, "POST", holds+"/"+hold+"/capture", ""); status != 200 || response["hold"].(map[string]any)["status"] != HoldStatusCaptured {
+		t.Errorf("expected the hold to be captured, but received %d with %v", status, response)
+	}
+
+	if status, response := performRequest(t, api, "POST", holds+"/"+hold+"/release", ""); status != 409 || response["error"] != "The hold is captured." {
+		t.Errorf("expected status 409 while releasing a captured hold, but received %d with %v", status, response)
+	}
+
+	// 9 points were redeemed and 40 captured from 109
+	if status, response := performRequest(t, api, "GET", "/customers/"+customer.Id+"/balance", ""); status != 200 || response["balance"] != float64(60) {
+		t.Errorf("expected a balance of 60, but received %d with %v", status, response)
+	}
+}
@@ -0,0 +1,789 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The built-in scoring rules, written in the same format as a rule file
+//
+//go:embed rules/default.yml
+var defaultRuleFile []byte
+
+// Quantities that points can be awarded per unit of
+const (
+	// the number of letters and digits in the retailer name
+	QuantityRetailerAlphanumeric = "retailer-alphanumeric"
+
+	// the number of items on the receipt
+	QuantityItems = "items"
+
+	// the number of complete pairs of items on the receipt
+	QuantityItemPairs = "item-pairs"
+
+	// the receipt total in dollars
+	QuantityTotal = "total"
+
+	// the item price in dollars, only available to rules evaluated for each item
+	QuantityItemPrice = "item-price"
+)
+
+// How fractional points are rounded to a whole number
+const (
+	RoundFloor = "floor"
+	RoundCeil  = "ceil"
+)
+
+var (
+	ruleNamePattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	alphanumericPattern = regexp.MustCompile(`[A-Za-z0-9]`)
+)
+
+// A file of declarative scoring rules, written in either YAML or JSON
+type RuleFile struct {
+	Rules []RuleDefinition `yaml:"rules"`
+}
+
+// A single declarative scoring rule. The rule fires when every receipt condition holds, awarding the points described by the
+// action. Rules with item conditions are evaluated for each item instead, and fire once for every item that matches.
+type RuleDefinition struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	When        *ReceiptCondition `yaml:"when"`
+	ForEachItem *ItemCondition    `yaml:"forEachItem"`
+	Points      PointsAction      `yaml:"points"`
+
+	// a text/template used to explain why the rule fired, eg. "purchase day {{.Day}} is odd"
+	Reason string `yaml:"reason"`
+}
+
+type ReceiptCondition struct {
+	Retailer     *TextCondition   `yaml:"retailer"`
+	Total        *AmountCondition `yaml:"total"`
+	ItemCount    *CountCondition  `yaml:"itemCount"`
+	PurchaseDate *DateCondition   `yaml:"purchaseDate"`
+	PurchaseTime *TimeCondition   `yaml:"purchaseTime"`
+
+	// an expression that must result in true, eg. retailer matches "(?i)target" && weekday in [SAT, SUN]
+	Expression string `yaml:"expression"`
+}
+
+type ItemCondition struct {
+	Description *TextCondition   `yaml:"description"`
+	Price       *AmountCondition `yaml:"price"`
+
+	// an expression over the receipt and item that must result in true, eg. description contains "Gatorade" && price >= 2
+	Expression string `yaml:"expression"`
+}
+
+// Conditions on text, which is trimmed of surrounding whitespace before it is compared. Equals and contains ignore case.
+type TextCondition struct {
+	Equals           string `yaml:"equals"`
+	Contains         string `yaml:"contains"`
+	Matches          string `yaml:"matches"`
+	LengthMultipleOf int    `yaml:"lengthMultipleOf"`
+}
+
+// Conditions on an amount of money, where min and max are inclusive
+type AmountCondition struct {
+	Min        string `yaml:"min"`
+	Max        string `yaml:"max"`
+	MultipleOf string `yaml:"multipleOf"`
+}
+
+// Conditions on a count, where min and max are inclusive
+type CountCondition struct {
+	Min        *int `yaml:"min"`
+	Max        *int `yaml:"max"`
+	MultipleOf int  `yaml:"multipleOf"`
+}
+
+// Conditions on the local purchase date, where from and to are inclusive
+type DateCondition struct {
+	From       string   `yaml:"from"`
+	To         string   `yaml:"to"`
+	Odd        *bool    `yaml:"odd"`
+	DaysOfWeek []string `yaml:"daysOfWeek"`
+}
+
+// Conditions on the local purchase time, where the window is written in interval notation, eg. (14:00,16:00)
+type TimeCondition struct {
+	Window string `yaml:"window"`
+}
+
+// The points awarded when a rule fires. Either a flat number of points, a multiplier applied to a quantity, or an expression.
+// Quantities and expressions are rounded to a whole number of points (down, unless round is ceil).
+type PointsAction struct {
+	Flat       *int   `yaml:"flat"`
+	Per        string `yaml:"per"`
+	Multiplier string `yaml:"multiplier"`
+	Expression string `yaml:"expression"`
+	Round      string `yaml:"round"`
+}
+
+// A scoring rule compiled from a declarative definition
+type DeclarativeRule struct {
+	Definition RuleDefinition
+
+	receiptMatchers []func(receipt *Receipt) (bool, error)
+	itemMatchers    []func(receipt *Receipt, index int, item *ReceiptItem) (bool, error)
+	multiplier      *big.Rat
+	points          *Expression
+	reason          *template.Template
+}
+
+// The values available to reason templates. Item values are only set for rules evaluated for each item.
+type ruleReasonData struct {
+	Retailer     string
+	Total        string
+	ItemCount    int
+	PurchaseDate string
+	PurchaseTime string
+	Day          int
+	Index        int
+	Description  string
+	Length       int
+	Price        string
+	Quantity     string
+	Points       int
+}
+
+func (rule *DeclarativeRule) Name() string {
+	return rule.Definition.Name
+}
+
+func (rule *DeclarativeRule) Evaluate(receipt *Receipt) ([]RuleResult, error) {
+	for _, match := range rule.receiptMatchers {
+		if matched, err := match(receipt); err != nil || !matched {
+			return nil, err
+		}
+	}
+
+	data := ruleReasonData{
+		Retailer:     receipt.Retailer,
+		Total:        receipt.PurchaseTotal,
+		ItemCount:    len(receipt.Items),
+		PurchaseDate: receipt.PurchaseDate,
+		PurchaseTime: receipt.PurchaseTime,
+	}
+
+	if purchaseDatetime, err := receipt.GetPurchaseDatetime(); err == nil {
+		data.Day = purchaseDatetime.Day()
+	}
+
+	if rule.Definition.ForEachItem == nil {
+		quantity, err := rule.quantity(receipt, 0, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return rule.fire(data, quantity)
+	}
+
+	results := make([]RuleResult, 0)
+
+	for i := range receipt.Items {
+		item := &receipt.Items[i]
+		matched := true
+
+		for _, match := range rule.itemMatchers {
+			if ok, err := match(receipt, i, item); err != nil {
+				return nil, fmt.Errorf("unable to evaluate item %d. %s", i, err)
+			} else if !ok {
+				matched = false
+				break
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		quantity, err := rule.quantity(receipt, i, item)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to evaluate item %d. %s", i, err)
+		}
+
+		itemData := data
+		itemData.Index = i
+		itemData.Description = strings.TrimSpace(item.ShortDescription)
+		itemData.Length = len(itemData.Description)
+		itemData.Price = item.Price
+
+		itemResults, err := rule.fire(itemData, quantity)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, itemResults...)
+	}
+
+	return results, nil
+}
+
+// Get the quantity that points are awarded per unit of, or nil for a flat number of points
+func (rule *DeclarativeRule) quantity(receipt *Receipt, index int, item *ReceiptItem) (*big.Rat, error) {
+	if rule.points != nil {
+		values, err := expressionValues(receipt, index, item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := rule.points.EvaluateNumber(values)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// expressions can't take points away
+		if value.Sign() < 0 {
+			return new(big.Rat), nil
+		}
+
+		return value, nil
+	}
+
+	switch rule.Definition.Points.Per {
+	case QuantityRetailerAlphanumeric:
+		return big.NewRat(int64(len(alphanumericPattern.FindAllString(receipt.Retailer, -1))), 1), nil
+	case QuantityItems:
+		return big.NewRat(int64(len(receipt.Items)), 1), nil
+	case QuantityItemPairs:
+		return big.NewRat(int64(len(receipt.Items)/2), 1), nil
+	case QuantityTotal:
+		total, err := receipt.GetPurchaseTotal()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return big.NewRat(total.Cents(), 100), nil
+	case QuantityItemPrice:
+		price, err := item.GetPrice()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return big.NewRat(price.Cents(), 100), nil
+	default:
+		return nil, nil
+	}
+}
+
+// Calculate the points for a quantity and explain them, returning no results if no points are awarded
+func (rule *DeclarativeRule) fire(data ruleReasonData, quantity *big.Rat) ([]RuleResult, error) {
+	points := 0
+
+	if quantity == nil {
+		points = *rule.Definition.Points.Flat
+		data.Quantity = "1"
+	} else {
+		product := new(big.Rat).Mul(quantity, rule.multiplier)
+		rounded := new(big.Int).Div(product.Num(), product.Denom())
+
+		if rule.Definition.Points.Round == RoundCeil && !product.IsInt() {
+			rounded.Add(rounded, big.NewInt(1))
+		}
+
+		if !rounded.IsInt64() || rounded.Int64() > int64(^uint32(0)>>1) {
+			return nil, fmt.Errorf("points overflow for quantity %s", quantity.RatString())
+		}
+
+		points = int(rounded.Int64())
+		data.Quantity = quantity.RatString()
+
+		if !quantity.IsInt() {
+			data.Quantity = quantity.FloatString(2)
+		}
+	}
+
+	if points == 0 {
+		return nil, nil
+	}
+
+	data.Points = points
+
+	var reason bytes.Buffer
+
+	if err := rule.reason.Execute(&reason, data); err != nil {
+		return nil, fmt.Errorf("unable to explain points. %s", err)
+	}
+
+	return fired(rule, points, "%s", reason.String()), nil
+}
+
+// Parse a rule file written in YAML or JSON, compiling every rule. All problems found in the file are returned together.
+func ParseRuleFile(data []byte) ([]*DeclarativeRule, error) {
+	var file RuleFile
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&file); errors.Is(err, io.EOF) {
+		return nil, errors.New("rule file is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to parse rule file. %s", err)
+	}
+
+	if len(file.Rules) == 0 {
+		return nil, errors.New("rule file does not contain any rules")
+	}
+
+	rules := make([]*DeclarativeRule, 0, len(file.Rules))
+	problems := make([]error, 0)
+	seen := make(map[string]bool)
+
+	for i, definition := range file.Rules {
+		if seen[definition.Name] {
+			problems = append(problems, fmt.Errorf("rule %d (%s): name is used by another rule", i, definition.Name))
+			continue
+		}
+
+		seen[definition.Name] = true
+
+		rule, err := CompileRuleDefinition(definition)
+
+		if err != nil {
+			// report each problem on its own line, prefixed with the rule it belongs to
+			errs := []error{err}
+
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				errs = joined.Unwrap()
+			}
+
+			for _, err := range errs {
+				problems = append(problems, fmt.Errorf("rule %d (%s): %w", i, definition.Name, err))
+			}
+
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+
+	return rules, nil
+}
+
+// Load and compile the rules in a rule file
+func LoadRuleFile(path string) ([]*DeclarativeRule, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rule file %s. %s", path, err)
+	}
+
+	rules, err := ParseRuleFile(data)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule file %s. %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// Compile a single rule definition, returning every problem with the definition
+func CompileRuleDefinition(definition RuleDefinition) (*DeclarativeRule, error) {
+	rule := &DeclarativeRule{Definition: definition}
+	problems := make([]error, 0)
+
+	if !ruleNamePattern.MatchString(definition.Name) {
+		problems = append(problems, fmt.Errorf("name must contain only lowercase letters, digits and dashes"))
+	}
+
+	if when := definition.When; when != nil {
+		if when.Retailer != nil {
+			match, errs := compileTextCondition("when.retailer", when.Retailer)
+			problems = append(problems, errs...)
+			rule.receiptMatchers = append(rule.receiptMatchers, func(receipt *Receipt) (bool, error) {
+				return match(receipt.Retailer), nil
+			})
+		}
+
+		if when.Total != nil {
+			match, errs := compileAmountCondition("when.total", when.Total)
+			problems = append(problems, errs...)
+			rule.receiptMatchers = append(rule.receiptMatchers, func(receipt *Receipt) (bool, error) {
+				total, err := receipt.GetPurchaseTotal()
+
+				return err == nil && match(total), err
+			})
+		}
+
+		if when.ItemCount != nil {
+			match, errs := compileCountCondition("when.itemCount", when.ItemCount)
+			problems = append(problems, errs...)
+			rule.receiptMatchers = append(rule.receiptMatchers, func(receipt *Receipt) (bool, error) {
+				return match(len(receipt.Items)), nil
+			})
+		}
+
+		if when.PurchaseDate != nil {
+			match, errs := compileDateCondition("when.purchaseDate", when.PurchaseDate)
+			problems = append(problems, errs...)
+			rule.receiptMatchers = append(rule.receiptMatchers, func(receipt *Receipt) (bool, error) {
+				purchaseDatetime, err := receipt.GetPurchaseDatetime()
+
+				if err != nil {
+					return false, fmt.Errorf("unable to parse time. %s", err)
+				}
+
+				return match(receipt.PurchaseDate, purchaseDatetime), nil
+			})
+		}
+
+		if when.PurchaseTime != nil {
+			window, err := ParseTimeWindow(when.PurchaseTime.Window)
+
+			if err != nil {
+				problems = append(problems, fmt.Errorf("when.purchaseTime.window: %s", err))
+			}
+
+			rule.receiptMatchers = append(rule.receiptMatchers, func(receipt *Receipt) (bool, error) {
+				purchaseDatetime, err := receipt.GetPurchaseDatetime()
+
+				if err != nil {
+					return false, fmt.Errorf("unable to parse time. %s", err)
+				}
+
+				return window.Contains(purchaseDatetime.Hour()*60 + purchaseDatetime.Minute()), nil
+			})
+		}
+
+		if when.Expression != "" {
+			expression, err := CompileExpression(when.Expression, receiptExpressionVariables)
+
+			if err != nil {
+				problems = append(problems, fmt.Errorf("when.expression: %s", err))
+			}
+
+			rule.receiptMatchers = append(rule.receiptMatchers, func(receipt *Receipt) (bool, error) {
+				values, err := receiptExpressionValues(receipt)
+
+				if err != nil {
+					return false, err
+				}
+
+				return expression.EvaluateBool(values)
+			})
+		}
+	}
+
+	if forEachItem := definition.ForEachItem; forEachItem != nil {
+		if forEachItem.Description != nil {
+			match, errs := compileTextCondition("forEachItem.description", forEachItem.Description)
+			problems = append(problems, errs...)
+			rule.itemMatchers = append(rule.itemMatchers, func(receipt *Receipt, index int, item *ReceiptItem) (bool, error) {
+				return match(item.ShortDescription), nil
+			})
+		}
+
+		if forEachItem.Price != nil {
+			match, errs := compileAmountCondition("forEachItem.price", forEachItem.Price)
+			problems = append(problems, errs...)
+			rule.itemMatchers = append(rule.itemMatchers, func(receipt *Receipt, index int, item *ReceiptItem) (bool, error) {
+				price, err := item.GetPrice()
+
+				return err == nil && match(price), err
+			})
+		}
+
+		if forEachItem.Expression != "" {
+			expression, err := CompileExpression(forEachItem.Expression, slices.Concat(receiptExpressionVariables, itemExpressionVariables))
+
+			if err != nil {
+				problems = append(problems, fmt.Errorf("forEachItem.expression: %s", err))
+			}
+
+			rule.itemMatchers = append(rule.itemMatchers, func(receipt *Receipt, index int, item *ReceiptItem) (bool, error) {
+				values, err := expressionValues(receipt, index, item)
+
+				if err != nil {
+					return false, err
+				}
+
+				return expression.EvaluateBool(values)
+			})
+		}
+	}
+
+	problems = append(problems, rule.compilePoints()...)
+
+	// default to the description when no reason is given
+	reason := definition.Reason
+
+	if reason == "" {
+		reason = definition.Description
+	}
+
+	if reason == "" {
+		reason = definition.Name
+	}
+
+	tmpl, err := template.New(definition.Name).Option("missingkey=error").Parse(reason)
+
+	if err == nil {
+		// execute the template once so that references to unknown values are found now rather than while scoring
+		err = tmpl.Execute(io.Discard, ruleReasonData{})
+	}
+
+	if err != nil {
+		problems = append(problems, fmt.Errorf("reason: %s", err))
+	}
+
+	rule.reason = tmpl
+
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+
+	return rule, nil
+}
+
+// Validate the points action and parse its multiplier
+func (rule *DeclarativeRule) compilePoints() []error {
+	action := rule.Definition.Points
+	problems := make([]error, 0)
+
+	actions := 0
+
+	for _, set := range []bool{action.Flat != nil, action.Per != "", action.Expression != ""} {
+		if set {
+			actions++
+		}
+	}
+
+	if actions != 1 {
+		return append(problems, errors.New("points must include exactly one of flat, per or expression"))
+	}
+
+	if action.Flat != nil {
+		if *action.Flat < 0 {
+			problems = append(problems, errors.New("points.flat must not be negative"))
+		}
+
+		if action.Multiplier != "" || action.Round != "" {
+			problems = append(problems, errors.New("points.multiplier and points.round can only be used with points.per"))
+		}
+
+		return problems
+	}
+
+	if action.Round != "" && action.Round != RoundFloor && action.Round != RoundCeil {
+		problems = append(problems, fmt.Errorf("points.round must be either %s or %s", RoundFloor, RoundCeil))
+	}
+
+	if action.Expression != "" {
+		variables := receiptExpressionVariables
+
+		if rule.Definition.ForEachItem != nil {
+			variables = slices.Concat(receiptExpressionVariables, itemExpressionVariables)
+		}
+
+		expression, err := CompileExpression(action.Expression, variables)
+
+		if err != nil {
+			problems = append(problems, fmt.Errorf("points.expression: %s", err))
+		}
+
+		if action.Multiplier != "" {
+			problems = append(problems, errors.New("points.multiplier can only be used with points.per"))
+		}
+
+		rule.points = expression
+		rule.multiplier = big.NewRat(1, 1)
+
+		return problems
+	}
+
+	switch action.Per {
+	case QuantityRetailerAlphanumeric, QuantityItems, QuantityItemPairs, QuantityTotal:
+	case QuantityItemPrice:
+		if rule.Definition.ForEachItem == nil {
+			problems = append(problems, fmt.Errorf("points.per %s can only be used with forEachItem", action.Per))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("points.per must be one of %s, %s, %s, %s or %s", QuantityRetailerAlphanumeric,
+			QuantityItems, QuantityItemPairs, QuantityTotal, QuantityItemPrice))
+	}
+
+	rule.multiplier = big.NewRat(1, 1)
+
+	if action.Multiplier != "" {
+		multiplier, ok := new(big.Rat).SetString(action.Multiplier)
+
+		if !ok || multiplier.Sign() < 0 {
+			problems = append(problems, fmt.Errorf("points.multiplier %s must be a number that is not negative", action.Multiplier))
+		} else {
+			rule.multiplier = multiplier
+		}
+	}
+
+	return problems
+}
+
+// Get the expression variables for a receipt, along with the item variables when an item is given
+func expressionValues(receipt *Receipt, index int, item *ReceiptItem) (map[string]any, error) {
+	values, err := receiptExpressionValues(receipt)
+
+	if err != nil || item == nil {
+		return values, err
+	}
+
+	return itemExpressionValues(values, index, item)
+}
+
+func compileTextCondition(path string, condition *TextCondition) (func(value string) bool, []error) {
+	problems := make([]error, 0)
+
+	var pattern *regexp.Regexp
+
+	if condition.Matches != "" {
+		var err error
+
+		if pattern, err = regexp.Compile(condition.Matches); err != nil {
+			problems = append(problems, fmt.Errorf("%s.matches: %s", path, err))
+		}
+	}
+
+	if condition.LengthMultipleOf < 0 {
+		problems = append(problems, fmt.Errorf("%s.lengthMultipleOf must not be negative", path))
+	}
+
+	if *condition == (TextCondition{}) {
+		problems = append(problems, fmt.Errorf("%s must include at least one condition", path))
+	}
+
+	return func(value string) bool {
+		value = strings.TrimSpace(value)
+
+		if condition.Equals != "" && !strings.EqualFold(value, strings.TrimSpace(condition.Equals)) {
+			return false
+		}
+
+		if condition.Contains != "" && !strings.Contains(strings.ToLower(value), strings.ToLower(condition.Contains)) {
+			return false
+		}
+
+		if pattern != nil && !pattern.MatchString(value) {
+			return false
+		}
+
+		if condition.LengthMultipleOf > 0 && len(value)%condition.LengthMultipleOf != 0 {
+			return false
+		}
+
+		return true
+	}, problems
+}
+
+func compileAmountCondition(path string, condition *AmountCondition) (func(value Money) bool, []error) {
+	problems := make([]error, 0)
+
+	parse := func(field string, value string) *Money {
+		if value == "" {
+			return nil
+		}
+
+		amount, err := ParseMoney(value)
+
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s.%s: %s", path, field, err))
+			return nil
+		}
+
+		return &amount
+	}
+
+	min := parse("min", condition.Min)
+	max := parse("max", condition.Max)
+	multipleOf := parse("multipleOf", condition.MultipleOf)
+
+	if multipleOf != nil && *multipleOf == 0 {
+		problems = append(problems, fmt.Errorf("%s.multipleOf must be greater than 0.00", path))
+	}
+
+	if *condition == (AmountCondition{}) {
+		problems = append(problems, fmt.Errorf("%s must include at least one condition", path))
+	}
+
+	return func(value Money) bool {
+		return (min == nil || value >= *min) && (max == nil || value <= *max) &&
+			(multipleOf == nil || value.IsMultipleOf(*multipleOf))
+	}, problems
+}
+
+func compileCountCondition(path string, condition *CountCondition) (func(value int) bool, []error) {
+	problems := make([]error, 0)
+
+	if condition.MultipleOf < 0 {
+		problems = append(problems, fmt.Errorf("%s.multipleOf must not be negative", path))
+	}
+
+	if condition.Min == nil && condition.Max == nil && condition.MultipleOf == 0 {
+		problems = append(problems, fmt.Errorf("%s must include at least one condition", path))
+	}
+
+	return func(value int) bool {
+		return (condition.Min == nil || value >= *condition.Min) && (condition.Max == nil || value <= *condition.Max) &&
+			(condition.MultipleOf <= 0 || value%condition.MultipleOf == 0)
+	}, problems
+}
+
+func compileDateCondition(path string, condition *DateCondition) (func(date string, purchaseDatetime *time.Time) bool, []error) {
+	problems := make([]error, 0)
+
+	for field, value := range map[string]string{"from": condition.From, "to": condition.To} {
+		if _, err := time.Parse("2006-01-02", value); value != "" && err != nil {
+			problems = append(problems, fmt.Errorf("%s.%s must be a date in the format YYYY-MM-DD", path, field))
+		}
+	}
+
+	days := make(map[time.Weekday]bool)
+
+	for _, name := range condition.DaysOfWeek {
+		found := false
+
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.EqualFold(name, day.String()) {
+				days[day] = true
+				found = true
+			}
+		}
+
+		if !found {
+			problems = append(problems, fmt.Errorf("%s.daysOfWeek: unknown day %s", path, name))
+		}
+	}
+
+	if condition.From == "" && condition.To == "" && condition.Odd == nil && len(condition.DaysOfWeek) == 0 {
+		problems = append(problems, fmt.Errorf("%s must include at least one condition", path))
+	}
+
+	// dates are always formatted as YYYY-MM-DD, so they can be compared as strings
+	return func(date string, purchaseDatetime *time.Time) bool {
+		return (condition.From == "" || date >= condition.From) && (condition.To == "" || date <= condition.To) &&
+			(condition.Odd == nil || (purchaseDatetime.Day()%2 != 0) == *condition.Odd) &&
+			(len(days) == 0 || days[purchaseDatetime.Weekday()])
+	}, problems
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeclarativeRules(t *testing.T) {
+	rules, err := ParseRuleFile([]byte(`
+rules:
+  - name: weekend-target
+    when:
+      retailer: {equals: target}
+      purchaseDate: {daysOfWeek: [Saturday, Sunday]}
+    points: {flat: 20}
+    reason: "weekend at {{.Retailer}}"
+  - name: gatorade-bonus
+    forEachItem:
+      description: {contains: gatorade}
+      price: {min: 2.00}
+    points: {per: item-price, multiplier: "1.5"}
+    reason: "item {{.Index}} earns floor({{.Price}}*1.5)={{.Points}}"
+  - name: big-basket
+    when:
+      itemCount: {min: 4}
+      total: {max: "100.00"}
+    points: {per: total, multiplier: "2", round: ceil}
+`))
+
+	if err != nil {
+		t.Fatalf("unexpected error while parsing rule file. %s", err)
+	}
+
+	names := make([]string, 0, len(rules))
+	ruleSet := &RuleSet{}
+
+	for _, rule := range rules {
+		names = append(names, rule.Name())
+		ruleSet.Rules = append(ruleSet.Rules, rule)
+	}
+
+	if strings.Join(names, ",") != "weekend-target,gatorade-bonus,big-basket" {
+		t.Fatalf("expected rules in file order, but received %v", names)
+	}
+
+	// 2022-03-20 is a Sunday
+	receipt := &Receipt{
+		Retailer:      "Target",
+		PurchaseDate:  "2022-03-20",
+		PurchaseTime:  "14:33",
+		PurchaseTotal: "9.01",
+		Items: []ReceiptItem{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "gatorade", Price: "1.99"},
+			{ShortDescription: "Gatorade", Price: "2.00"},
+			{ShortDescription: "Pepsi", Price: "2.77"},
+		},
+	}
+
+	breakdown, err := ruleSet.GetPointsBreakdown(receipt)
+
+	if err != nil {
+		t.Fatalf("unexpected error while calculating points. %s", err)
+	}
+
+	// 20 weekend + floor(2.25*1.5)=3 + floor(2.00*1.5)=3 + ceil(9.01*2)=19
+	if breakdown.Points != 45 || len(breakdown.Results) != 4 {
+		t.Errorf("expected 45 points from 4 results, but received %v", breakdown)
+	}
+
+	if reason := breakdown.Results[2].Reason; reason != "item 2 earns floor(2.00*1.5)=3" {
+		t.Errorf("expected reason for item 2, but received %q", reason)
+	}
+
+	// on a weekday only the item and total rules fire
+	receipt.PurchaseDate = "2022-03-21"
+	receipt.parsedPurchaseDatetime = nil
+
+	if points, _ := ruleSet.GetPoints(receipt); points != 25 {
+		t.Errorf("expected 25 points on a weekday, but received %d", points)
+	}
+}
+
+func TestDeclarativeRuleExpressions(t *testing.T) {
+	rules, err := ParseRuleFile([]byte(`
+rules:
+  - name: weekend-target
+    when:
+      expression: retailer matches "(?i)target" && total >= 20 && weekday in [SAT, SUN]
+    points: {expression: "floor(total / 10) * 5"}
+  - name: long-gatorade
+    forEachItem:
+      expression: lower(description) contains "gatorade" && index > 0
+    points: {expression: "price * 2 - length", round: ceil}
+`))
+
+	if err != nil {
+		t.Fatalf("unexpected error while parsing rule file. %s", err)
+	}
+
+	ruleSet := &RuleSet{}
+
+	for _, rule := range rules {
+		ruleSet.Rules = append(ruleSet.Rules, rule)
+	}
+
+	// 2022-03-20 is a Sunday
+	receipt := &Receipt{
+		Retailer:      "Target",
+		PurchaseDate:  "2022-03-20",
+		PurchaseTime:  "14:33",
+		PurchaseTotal: "35.35",
+		Items: []ReceiptItem{
+			{ShortDescription: "Gatorade", Price: "9.00"},
+			{ShortDescription: "Gatorade", Price: "8.25"},
+			{ShortDescription: "Gatorade", Price: "3.00"},
+			{ShortDescription: "Pepsi", Price: "15.10"},
+		},
+	}
+
+	breakdown, err := ruleSet.GetPointsBreakdown(receipt)
+
+	if err != nil {
+		t.Fatalf("unexpected error while calculating points. %s", err)
+	}
+
+	// floor(35.35/10)*5=15 + ceil(8.25*2-8)=9, and the item earning 3.00*2-8 points is not awarded anything
+	if breakdown.Points != 24 || len(breakdown.Results) != 2 {
+		t.Errorf("expected 24 points from 2 results, but received %v", breakdown)
+	}
+}
+
+func TestParseRuleFileJson(t *testing.T) {
+	rules, err := ParseRuleFile([]byte(`{"rules": [{"name": "flat", "points": {"flat": 5}}]}`))
+
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("expected 1 rule from JSON, but received %d with error %v", len(rules), err)
+	}
+
+	results, _ := rules[0].Evaluate(&Receipt{})
+
+	if len(results) != 1 || results[0].Points != 5 || results[0].Reason != "flat" {
+		t.Errorf("expected 5 points explained by the rule name, but received %v", results)
+	}
+}
+
+func TestParseRuleFileInvalid(t *testing.T) {
+	cases := map[string]string{
+		"empty":            ``,
+		"no rules":         `rules: []`,
+		"unknown field":    `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"retailr": {"equals": "x"}}}]}`,
+		"bad name":         `{"rules": [{"name": "Bad Name", "points": {"flat": 1}}]}`,
+		"duplicate name":   `{"rules": [{"name": "a", "points": {"flat": 1}}, {"name": "a", "points": {"flat": 2}}]}`,
+		"flat and per":     `{"rules": [{"name": "a", "points": {"flat": 1, "per": "items"}}]}`,
+		"no points":        `{"rules": [{"name": "a", "points": {}}]}`,
+		"negative":         `{"rules": [{"name": "a", "points": {"flat": -1}}]}`,
+		"unknown quantity": `{"rules": [{"name": "a", "points": {"per": "stars"}}]}`,
+		"item price":       `{"rules": [{"name": "a", "points": {"per": "item-price"}}]}`,
+		"bad multiplier":   `{"rules": [{"name": "a", "points": {"per": "items", "multiplier": "x"}}]}`,
+		"bad round":        `{"rules": [{"name": "a", "points": {"per": "items", "round": "up"}}]}`,
+		"bad money":        `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"total": {"min": "1.5"}}}]}`,
+		"zero multipleOf":  `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"total": {"multipleOf": "0.00"}}}]}`,
+		"empty condition":  `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"retailer": {}}}]}`,
+		"bad regexp":       `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"retailer": {"matches": "("}}}]}`,
+		"bad date":         `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"purchaseDate": {"from": "2022-13-01"}}}]}`,
+		"bad day":          `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"purchaseDate": {"daysOfWeek": ["funday"]}}}]}`,
+		"bad window":       `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"purchaseTime": {"window": "14:00-16:00"}}}]}`,
+		"bad reason":       `{"rules": [{"name": "a", "points": {"flat": 1}, "reason": "{{.Nope}}"}]}`,
+		"bad expression":   `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"expression": "total >"}}]}`,
+		"item variable":    `{"rules": [{"name": "a", "points": {"flat": 1}, "when": {"expression": "price > 1"}}]}`,
+		"item points":      `{"rules": [{"name": "a", "points": {"expression": "price"}}]}`,
+		"flat and expr":    `{"rules": [{"name": "a", "points": {"flat": 1, "expression": "total"}}]}`,
+		"expr multiplier":  `{"rules": [{"name": "a", "points": {"expression": "total", "multiplier": "2"}}]}`,
+	}
+
+	for name, file := range cases {
+		if _, err := ParseRuleFile([]byte(file)); err == nil {
+			t.Errorf("expected error while parsing rule file with %s, but received nil", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestNewRuleSetOrder(t *testing.T) {
+	rules, err := NewRuleSet([]string{"odd-purchase-day", "retailer-name"})
+
+	if err != nil {
+		t.Fatalf("unexpected error while building rule set. %s", err)
+	}
+
+	if len(rules.Rules) != 2 || rules.Rules[0].Name() != "odd-purchase-day" || rules.Rules[1].Name() != "retailer-name" {
+		t.Errorf("expected rules to be evaluated in configured order, but received %v", rules.Rules)
+	}
+}
+
+func TestNewRuleSetUnknownRule(t *testing.T) {
+	if _, err := NewRuleSet([]string{"retailer-name", "does-not-exist"}); err == nil {
+		t.Errorf("expected error while building rule set with an unknown rule, but received nil")
+	}
+
+	if _, err := NewRuleSet([]string{"retailer-name", "retailer-name"}); err == nil {
+		t.Errorf("expected error while building rule set with a duplicate rule, but received nil")
+	}
+}
+
+func TestRuleSetDisabledRules(t *testing.T) {
+	a := &Receipt{
+		Retailer:      "M&M Corner Market",
+		PurchaseDate:  "2022-03-20",
+		PurchaseTime:  "14:33",
+		PurchaseTotal: "9.00",
+		Items: []ReceiptItem{
+			{
+				ShortDescription: "Gatorade",
+				Price:            "2.25",
+			},
+		},
+	}
+
+	// only the retailer name (14) and round dollar total (50) rules are enabled
+	rules, err := NewRuleSet([]string{"retailer-name", "round-dollar-total"})
+
+	if err != nil {
+		t.Fatalf("unexpected error while building rule set. %s", err)
+	}
+
+	points, err := rules.GetPoints(a)
+
+	if err != nil {
+		t.Errorf("unexpected error while calculating points. %s", err)
+	}
+
+	if points != 64 {
+		t.Errorf("expected 64 points, but received %d instead.", points)
+	}
+}
+
+func TestGetPointsBreakdown(t *testing.T) {
+	a := &Receipt{
+		Retailer:      "Target",
+		PurchaseDate:  "2022-01-01",
+		PurchaseTime:  "13:01",
+		PurchaseTotal: "35.35",
+		Items: []ReceiptItem{
+			{
+				ShortDescription: "Mountain Dew 12PK",
+				Price:            "6.49",
+			},
+			{
+				ShortDescription: "Emils Cheese Pizza",
+				Price:            "12.25",
+			},
+		},
+	}
+
+	breakdown, err := DefaultRuleSet().GetPointsBreakdown(a)
+
+	if err != nil {
+		t.Fatalf("unexpected error while calculating points breakdown. %s", err)
+	}
+
+	// 6 retailer + 5 item pair + 3 item description + 6 odd day
+	if breakdown.Points != 20 {
+		t.Errorf("expected 20 points, but received %d instead.", breakdown.Points)
+	}
+
+	sum := 0
+
+	for _, result := range breakdown.Results {
+		sum += result.Points
+	}
+
+	if sum != breakdown.Points {
+		t.Errorf("expected breakdown results to sum to %d, but received %d instead.", breakdown.Points, sum)
+	}
+
+	expected := "item 1 'Emils Cheese Pizza' length 18 is multiple of 3: ceil(12.25*0.2)=3"
+
+	if len(breakdown.Results) != 4 || breakdown.Results[2].Reason != expected {
+		t.Errorf("expected item description result with reason %q, but received %v", expected, breakdown.Results)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A catalog of named rule set versions. Receipts record the version that scored them, so that the points of any receipt can be
+// explained, and receipts can be re-scored under a different version.
+type RuleSetCatalog struct {
+	Active *RuleSet
+
+	sets map[string]*RuleSet
+}
+
+// Build a catalog from a list of rule set versions separated by semicolons, where each version is written as
+// version=rule,rule,... eg. spring-2025=retailer-name,item-pairs;summer-2025=retailer-name. Rule names are looked up in the
+// registry once, so later changes to the registry don't change a version. The active rule set is always included under its
+// own version.
+func NewRuleSetCatalog(registry *RuleRegistry, active *RuleSet, versions string) (*RuleSetCatalog, error) {
+	catalog := &RuleSetCatalog{
+		Active: active,
+		sets:   map[string]*RuleSet{active.Version: active},
+	}
+
+	for _, part := range strings.Split(versions, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		version, names, ok := strings.Cut(part, "=")
+
+		if version = strings.TrimSpace(version); !ok || version == "" {
+			return nil, fmt.Errorf("invalid rule set %s, expected version=rule,rule", part)
+		}
+
+		if _, exists := catalog.sets[version]; exists {
+			return nil, fmt.Errorf("rule set version %s is defined more than once", version)
+		}
+
+		rules := make([]string, 0)
+
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				rules = append(rules, name)
+			}
+		}
+
+		// an empty rule list would enable every rule, which is almost certainly a mistake in a versioned rule set
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("rule set version %s has no rules", version)
+		}
+
+		set, err := registry.NewRuleSet(rules)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule set version %s. %s", version, err)
+		}
+
+		set.Version = version
+		catalog.sets[version] = set
+	}
+
+	return catalog, nil
+}
+
+// Check that every receipt was scored by the rules its rule set version has now. A version whose rules changed since receipts
+// were scored can no longer explain or reproduce their points, so changed rules must be given a new version.
+func (catalog *RuleSetCatalog) CheckReceipts(receipts []*Receipt) error {
+	for _, receipt := range receipts {
+		rules, exists := catalog.Get(receipt.RulesVersion)
+
+		// receipts scored before fingerprints were recorded, or by a version that is no longer configured, can't be checked
+		if !exists || receipt.RulesFingerprint == "" {
+			continue
+		}
+
+		if fingerprint := rules.Fingerprint(); receipt.RulesFingerprint != fingerprint {
+			return fmt.Errorf("the rules of rule set version %s changed since receipt %s was scored (fingerprint %s, was %s), "+
+				"give the changed rules a new version", receipt.RulesVersion, receipt.GetId(), fingerprint, receipt.RulesFingerprint)
+		}
+	}
+
+	return nil
+}
+
+// Get the rule set with the given version
+func (catalog *RuleSetCatalog) Get(version string) (*RuleSet, bool) {
+	set, exists := catalog.sets[version]
+
+	return set, exists
+}
+
+// Get every version in the catalog, sorted by name
+func (catalog *RuleSetCatalog) Versions() []string {
+	versions := make([]string, 0, len(catalog.sets))
+
+	for version := range catalog.sets {
+		versions = append(versions, version)
+	}
+
+	sort.Strings(versions)
+
+	return versions
+}
+
+// Get the names of the rules in the set, in the order they are evaluated
+func (rules *RuleSet) RuleNames() []string {
+	names := make([]string, 0, len(rules.Rules))
+
+	for _, rule := range rules.Rules {
+		names = append(names, rule.Name())
+	}
+
+	return names
+}
+
+// Get a hash of the rules in the set, in the order they are evaluated. Declarative rules are hashed by their definition, so
+// the fingerprint changes whenever a rule is changed, even if its name and version stay the same.
+func (rules *RuleSet) Fingerprint() string {
+	hash := sha256.New()
+
+	for _, rule := range rules.Rules {
+		content := []byte(fmt.Sprintf("%s %T", rule.Name(), rule))
+
+		if declarative, ok := rule.(*DeclarativeRule); ok {
+			if definition, err := yaml.Marshal(declarative.Definition); err == nil {
+				content = definition
+			}
+		}
+
+		// the length prefix keeps the boundaries between rules unambiguous
+		fmt.Fprintf(hash, "%d:", len(content))
+		hash.Write(content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
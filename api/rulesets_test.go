@@ -0,0 +1,123 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestNewRuleSetCatalog(t *testing.T) {
+	active := DefaultRuleSet()
+	active.Version = "1"
+
+	catalog, err := NewRuleSetCatalog(DefaultRuleRegistry(), active, "spring=retailer-name, item-pairs; summer=retailer-name;")
+
+	if err != nil {
+		t.Fatalf("unexpected error while building rule set catalog. %s", err)
+	}
+
+	versions := catalog.Versions()
+
+	if len(versions) != 3 || versions[0] != "1" || versions[1] != "spring" || versions[2] != "summer" {
+		t.Errorf("expected versions 1, spring and summer, but received %v", versions)
+	}
+
+	if spring, _ := catalog.Get("spring"); spring.Version != "spring" || len(spring.RuleNames()) != 2 {
+		t.Errorf("expected the spring rule set to contain 2 rules, but received %v", spring.RuleNames())
+	}
+
+	for _, versions := range []string{"1=retailer-name", "spring=", "spring=retailer-name;spring=item-pairs", "=retailer-name", "spring=does-not-exist"} {
+		if _, err := NewRuleSetCatalog(DefaultRuleRegistry(), active, versions); err == nil {
+			t.Errorf("expected error while building rule set catalog %s, but received nil", versions)
+		}
+	}
+}
+
+func TestSetupApiKeepsBuiltInRules(t *testing.T) {
+	before := DefaultRuleSet().Fingerprint()
+
+	// the configured time window replaces the built-in afternoon-purchase rule for this API only
+	api := setupTestApi(t, func(config *Config) {
+		config.TimeWindowRules = "afternoon-purchase=[09:00,10:00):1"
+	})
+
+	if api.Rules.Fingerprint() == before {
+		t.Errorf("expected the configured rules to differ from the built-in rules")
+	}
+
+	if after := DefaultRuleSet().Fingerprint(); after != before {
+		t.Errorf("expected the built-in rules to be unchanged, but the fingerprint changed from %s to %s", before, after)
+	}
+}
+
+func TestCheckReceiptsRuleFingerprints(t *testing.T) {
+	active := DefaultRuleSet()
+	active.Version = "1"
+
+	catalog, _ := NewRuleSetCatalog(DefaultRuleRegistry(), active, "")
+
+	receipt := &Receipt{RulesVersion: "1", RulesFingerprint: active.Fingerprint()}
+	unchecked := &Receipt{RulesVersion: "retired", RulesFingerprint: "0000"}
+
+	if err := catalog.CheckReceipts([]*Receipt{receipt, unchecked}); err != nil {
+		t.Errorf("unexpected error while checking receipts scored by the same rules. %s", err)
+	}
+
+	// the same version with different rules can't explain the points of receipts it scored before
+	changed, _ := NewRuleSet([]string{"retailer-name"})
+	changed.Version = "1"
+
+	catalog, _ = NewRuleSetCatalog(DefaultRuleRegistry(), changed, "")
+
+	if err := catalog.CheckReceipts([]*Receipt{receipt}); err == nil {
+		t.Errorf("expected error while checking receipts scored by changed rules, but received nil")
+	}
+}
+
+func TestRescoreReceipts(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	rules, _ := NewRuleSet([]string{"retailer-name"})
+	rules.Version = "retailer-only"
+
+	// receipts B and A were purchased on 2022-01-02
+	match := func(receipt *Receipt) bool {
+		return receipt.PurchaseDate == "2022-01-02"
+	}
+
+	report, err := db.RescoreReceipts(rules, match, true)
+
+	if err != nil {
+		t.Fatalf("unexpected error while re-scoring receipts. %s", err)
+	}
+
+	if report.Rescored != 2 || report.Changed != 2 || len(report.Receipts) != 2 {
+		t.Fatalf("expected 2 receipts to be re-scored, but received %v", report)
+	}
+
+	// receipts are reported in purchase order, A (15 points) then B (31 points)
+	a := report.Receipts[0]
+
+	if a.Id != exampleIdA || *a.OldPoints != 15 || a.NewPoints != 9 || a.PointsChange != -6 || a.NewVersion != "retailer-only" {
+		t.Errorf("expected receipt A to change from 15 to 9 points, but received %v", a)
+	}
+
+	if report.PointsChange != -6+(6-31) {
+		t.Errorf("expected a total change of -31 points, but received %d", report.PointsChange)
+	}
+
+	// a dry run does not store the new points
+	if stored, _ := db.GetReceiptById(exampleIdA); *stored.Points != 15 || stored.RulesVersion != "" {
+		t.Errorf("expected dry run to keep 15 points, but received %d from %s", *stored.Points, stored.RulesVersion)
+	}
+
+	if _, err := db.RescoreReceipts(rules, match, false); err != nil {
+		t.Fatalf("unexpected error while re-scoring receipts. %s", err)
+	}
+
+	if stored, _ := db.GetReceiptById(exampleIdA); *stored.Points != 9 || stored.RulesVersion != "retailer-only" {
+		t.Errorf("expected 9 points from retailer-only, but received %d from %s", *stored.Points, stored.RulesVersion)
+	}
+
+	if stored, _ := db.GetReceiptById(exampleIdD); *stored.Points != 109 {
+		t.Errorf("expected receipt D outside of the date range to keep 109 points, but received %d", *stored.Points)
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Defaults and limits for the statistics reported by a rule simulation
+const (
+	DefaultSimulationBucketSize = 25
+	DefaultSimulationLimit      = 10
+)
+
+// Options for a rule simulation
+type SimulationOptions struct {
+	// the width of each histogram bucket, in points
+	BucketSize int
+
+	// the number of retailers and sample receipts to report
+	Limit int
+}
+
+// The number of receipts awarded points within a range, before and after a simulation
+type SimulationBucket struct {
+	From   int `json:"from"`
+	To     int `json:"to"`
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+// The receipts of a single retailer affected by a simulation
+type SimulationRetailer struct {
+	Retailer     string `json:"retailer"`
+	Receipts     int    `json:"receipts"`
+	Changed      int    `json:"changed"`
+	PointsChange int    `json:"pointsChange"`
+}
+
+// A receipt whose points changed in a simulation
+type SimulationSample struct {
+	Id           string `json:"id"`
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"`
+	OldPoints    int    `json:"oldPoints"`
+	NewPoints    int    `json:"newPoints"`
+	PointsChange int    `json:"pointsChange"`
+}
+
+// Identifies a rule set used by a simulation
+type SimulationRules struct {
+	RulesVersion string   `json:"rulesVersion,omitempty"`
+	Fingerprint  string   `json:"fingerprint"`
+	Rules        []string `json:"rules"`
+}
+
+// The impact of scoring stored receipts under a candidate rule set, compared to scoring them under the base rule set
+type SimulationReport struct {
+	Base         SimulationRules      `json:"base"`
+	Candidate    SimulationRules      `json:"candidate"`
+	Receipts     int                  `json:"receipts"`
+	Changed      int                  `json:"changed"`
+	PointsBefore int                  `json:"pointsBefore"`
+	PointsAfter  int                  `json:"pointsAfter"`
+	PointsChange int                  `json:"pointsChange"`
+	Histogram    []SimulationBucket   `json:"histogram"`
+	TopRetailers []SimulationRetailer `json:"topRetailers"`
+	Samples      []SimulationSample   `json:"samples"`
+}
+
+// Build a candidate rule set from a base rule set, where each candidate rule replaces the base rule with the same name or is
+// added after the base rules. The candidate rule set has no version, since it is not a configured rule set version.
+func (rules *RuleSet) WithRules(candidates []Rule) *RuleSet {
+	set := &RuleSet{
+		Rules: make([]Rule, 0, len(rules.Rules)+len(candidates)),
+	}
+
+	if len(candidates) == 0 {
+		set.Version = rules.Version
+	}
+
+	replaced := make(map[string]bool)
+
+	for _, rule := range rules.Rules {
+		for _, candidate := range candidates {
+			if candidate.Name() == rule.Name() {
+				rule = candidate
+				replaced[rule.Name()] = true
+			}
+		}
+
+		set.Rules = append(set.Rules, rule)
+	}
+
+	for _, candidate := range candidates {
+		if !replaced[candidate.Name()] {
+			set.Rules = append(set.Rules, candidate)
+		}
+	}
+
+	return set
+}
+
+// Score every receipt matching the query filters under a base and a candidate rule set and report the impact. Both rule sets
+// score the same remaining items, so the change only comes from the rules. Receipts are never modified.
+func (db ReceiptDatabase) SimulateRules(base *RuleSet, rules *RuleSet, query *ReceiptQuery, options SimulationOptions) (*SimulationReport, error) {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	it, err := txn.Get("receipt", "purchase_datetime")
+
+	if err != nil {
+		return nil, fmt.Errorf("error while querying receipts to simulate. %s", err)
+	}
+
+	report := &SimulationReport{
+		Base:         SimulationRules{RulesVersion: base.Version, Fingerprint: base.Fingerprint(), Rules: base.RuleNames()},
+		Candidate:    SimulationRules{RulesVersion: rules.Version, Fingerprint: rules.Fingerprint(), Rules: rules.RuleNames()},
+		Histogram:    make([]SimulationBucket, 0),
+		TopRetailers: make([]SimulationRetailer, 0),
+		Samples:      make([]SimulationSample, 0),
+	}
+
+	buckets := make(map[int]*SimulationBucket)
+	retailers := make(map[string]*SimulationRetailer)
+
+	bucketFor := func(points int) *SimulationBucket {
+		from := points / options.BucketSize * options.BucketSize
+
+		if buckets[from] == nil {
+			buckets[from] = &SimulationBucket{From: from, To: from + options.BucketSize - 1}
+		}
+
+		return buckets[from]
+	}
+
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		receipt := raw.(*Receipt)
+
+		// voided and refunded receipts are never re-scored
+		if receipt.IsClosed() {
+			continue
+		}
+
+		if query.Retailer != "" && !bytes.Equal(retailerKey(receipt.Retailer), retailerKey(query.Retailer)) {
+			continue
+		}
+
+		if matches, err := db.matchesQuery(receipt, query); err != nil || !matches {
+			continue
+		}
+
+		// score a copy without any refunded items. The stored points aren't compared, since they may be capped by a refund or
+		// scored by an older version of the rules.
+		remaining := receipt.GetRemaining()
+
+		oldPoints, err := base.GetPoints(remaining)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while calculating points for receipt %s. %s", receipt.GetId(), err)
+		}
+
+		points, err := rules.GetPoints(remaining)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while calculating points for receipt %s. %s", receipt.GetId(), err)
+		}
+
+		change := points - oldPoints
+
+		report.Receipts++
+		report.PointsBefore += oldPoints
+		report.PointsAfter += points
+		report.PointsChange += change
+
+		bucketFor(oldPoints).Before++
+		bucketFor(points).After++
+
+		name := strings.ToLower(strings.TrimSpace(receipt.Retailer))
+
+		if retailers[name] == nil {
+			retailers[name] = &SimulationRetailer{Retailer: strings.TrimSpace(receipt.Retailer)}
+		}
+
+		retailers[name].Receipts++
+		retailers[name].PointsChange += change
+
+		if change == 0 {
+			continue
+		}
+
+		report.Changed++
+		retailers[name].Changed++
+
+		report.Samples = append(report.Samples, SimulationSample{
+			Id:           receipt.GetId(),
+			Retailer:     receipt.Retailer,
+			PurchaseDate: receipt.PurchaseDate,
+			OldPoints:    oldPoints,
+			NewPoints:    points,
+			PointsChange: change,
+		})
+	}
+
+	for _, bucket := range buckets {
+		report.Histogram = append(report.Histogram, *bucket)
+	}
+
+	sort.Slice(report.Histogram, func(i, j int) bool {
+		return report.Histogram[i].From < report.Histogram[j].From
+	})
+
+	// the retailers and receipts with the largest change come first, in either direction
+	for _, retailer := range retailers {
+		if retailer.Changed > 0 {
+			report.TopRetailers = append(report.TopRetailers, *retailer)
+		}
+	}
+
+	sort.Slice(report.TopRetailers, func(i, j int) bool {
+		a, b := report.TopRetailers[i], report.TopRetailers[j]
+
+		if abs(a.PointsChange) != abs(b.PointsChange) {
+			return abs(a.PointsChange) > abs(b.PointsChange)
+		}
+
+		return strings.ToLower(a.Retailer) < strings.ToLower(b.Retailer)
+	})
+
+	sort.Slice(report.Samples, func(i, j int) bool {
+		a, b := report.Samples[i], report.Samples[j]
+
+		if abs(a.PointsChange) != abs(b.PointsChange) {
+			return abs(a.PointsChange) > abs(b.PointsChange)
+		}
+
+		return a.Id < b.Id
+	})
+
+	report.TopRetailers = report.TopRetailers[:min(len(report.TopRetailers), options.Limit)]
+	report.Samples = report.Samples[:min(len(report.Samples), options.Limit)]
+
+	return report, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSimulateRules(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	candidates, err := ParseRuleFile([]byte(`
+rules:
+  - name: target-bonus
+    when:
+      retailer: {equals: target}
+    points: {flat: 10}
+  - name: retailer-name
+    points: {per: retailer-alphanumeric}
+`))
+
+	if err != nil {
+		t.Fatalf("unexpected error while parsing rule file. %s", err)
+	}
+
+	rules := DefaultRuleSet().WithRules([]Rule{candidates[0], candidates[1]})
+
+	// the candidate retailer-name rule replaces the built-in rule in place, and the new rule is added at the end
+	if names := rules.RuleNames(); names[0] != "retailer-name" || names[len(names)-1] != "target-bonus" {
+		t.Errorf("expected candidate rules to replace or follow the base rules, but received %v", names)
+	}
+
+	report, err := db.SimulateRules(DefaultRuleSet(), rules, &ReceiptQuery{}, SimulationOptions{BucketSize: 25, Limit: 10})
+
+	if err != nil {
+		t.Fatalf("unexpected error while simulating rules. %s", err)
+	}
+
+	// only the two Target receipts change, each earning 10 more points
+	if report.Receipts != 4 || report.Changed != 2 || report.PointsBefore != 183 || report.PointsAfter != 203 || report.PointsChange != 20 {
+		t.Errorf("expected 4 receipts with 2 changed by 20 points, but received %+v", report)
+	}
+
+	if report.Candidate.RulesVersion != "" || report.Candidate.Fingerprint == report.Base.Fingerprint {
+		t.Errorf("expected the candidate rules to be identified apart from the base rules, but received %+v", report.Candidate)
+	}
+
+	histogram := []SimulationBucket{{0, 24, 1, 1}, {25, 49, 2, 2}, {100, 124, 1, 1}}
+
+	if !reflect.DeepEqual(report.Histogram, histogram) {
+		t.Errorf("expected histogram %v, but received %v", histogram, report.Histogram)
+	}
+
+	if len(report.TopRetailers) != 1 || report.TopRetailers[0] != (SimulationRetailer{"Target", 2, 2, 20}) {
+		t.Errorf("expected Target to be the only affected retailer, but received %v", report.TopRetailers)
+	}
+
+	if len(report.Samples) != 2 || report.Samples[0].Id != exampleIdC || report.Samples[1].Id != exampleIdB {
+		t.Errorf("expected samples C and B, but received %v", report.Samples)
+	}
+
+	// receipts are never modified by a simulation
+	if b, _ := db.GetReceiptById(exampleIdB); *b.Points != 31 {
+		t.Errorf("expected 31 stored points, but received %d", *b.Points)
+	}
+
+	// filters select a subset of receipts, and the limit applies to samples
+	report, err = db.SimulateRules(DefaultRuleSet(), rules, &ReceiptQuery{Retailer: " TARGET", PurchaseDateFrom: "2022-01-02"}, SimulationOptions{BucketSize: 25, Limit: 1})
+
+	if err != nil {
+		t.Fatalf("unexpected error while simulating rules. %s", err)
+	}
+
+	if report.Receipts != 1 || len(report.Samples) != 1 || report.Samples[0].Id != exampleIdB || report.Samples[0].NewPoints != 41 {
+		t.Errorf("expected only receipt B to be simulated, but received %+v", report)
+	}
+}
+
+func TestSimulateRulesComparesToBaseRules(t *testing.T) {
+	db := setupTestDatabase(t)
+
+	// the stored points of every receipt are scored by a different rule set than the one simulated
+	retailerOnly, _ := NewRuleSet([]string{"retailer-name"})
+	retailerOnly.Version = "retailer-only"
+
+	if _, err := db.RescoreReceipts(retailerOnly, func(receipt *Receipt) bool { return true }, false); err != nil {
+		t.Fatalf("unexpected error while re-scoring receipts. %s", err)
+	}
+
+	report, err := db.SimulateRules(DefaultRuleSet(), DefaultRuleSet(), &ReceiptQuery{}, SimulationOptions{BucketSize: 25, Limit: 10})
+
+	if err != nil {
+		t.Fatalf("unexpected error while simulating rules. %s", err)
+	}
+
+	// unchanged rules change nothing, whatever points are stored
+	if report.Receipts != 4 || report.Changed != 0 || report.PointsBefore != 183 || report.PointsChange != 0 {
+		t.Errorf("expected 4 unchanged receipts worth 183 points, but received %+v", report)
+	}
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	StorageBackendMemory = "memory"
+	StorageBackendFile   = "file"
+)
+
+const (
+	StorageRecordReceipt     = "receipt"
+	StorageRecordIdempotency = "idempotency"
+	StorageRecordCustomer    = "customer"
+	StorageRecordLedger      = "ledger"
+	StorageRecordHold        = "hold"
+)
+
+// A single change written to storage. Records are replayed in order to rebuild the in-memory database on startup, so applying
+// the same record more than once must have the same result as applying it once.
+type StorageRecord struct {
+	Type        string             `json:"type"`
+	Receipt     *Receipt           `json:"receipt,omitempty"`
+	Idempotency *IdempotencyRecord `json:"idempotency,omitempty"`
+	Customer    *Customer          `json:"customer,omitempty"`
+	LedgerEntry *LedgerEntry       `json:"ledgerEntry,omitempty"`
+	Hold        *Hold              `json:"hold,omitempty"`
+}
+
+// A durable home for the records that make up the database. The in-memory database remains the source of truth for queries, while
+// storage is only used to persist changes and restore them after a restart.
+type ReceiptStorage interface {
+	// Load every stored record in the order it was written
+	Load() ([]StorageRecord, error)
+
+	// Append one or more records to storage
+	Append(records ...StorageRecord) error
+
+	// Replace all stored records with a snapshot of the current database state
+	Snapshot(records []StorageRecord) error
+
+	// Flush any pending writes to durable storage
+	Flush() error
+
+	// Flush any pending writes and release all resources
+	Close() error
+}
+
+// Create the storage backend selected by configuration
+func NewReceiptStorage(config *Config) (ReceiptStorage, error) {
+	switch config.StorageBackend {
+	case StorageBackendMemory:
+		return &MemoryStorage{}, nil
+	case StorageBackendFile:
+		return OpenFileStorage(config.StoragePath)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %s", config.StorageBackend)
+	}
+}
+
+// A storage backend that does not persist anything. All data is lost when the server stops.
+type MemoryStorage struct{}
+
+func (storage *MemoryStorage) Load() ([]StorageRecord, error) {
+	return []StorageRecord{}, nil
+}
+
+func (storage *MemoryStorage) Append(records ...StorageRecord) error {
+	return nil
+}
+
+func (storage *MemoryStorage) Snapshot(records []StorageRecord) error {
+	return nil
+}
+
+func (storage *MemoryStorage) Flush() error {
+	return nil
+}
+
+func (storage *MemoryStorage) Close() error {
+	return nil
+}
+
+// A storage backend that keeps an append-only journal of records on disk alongside a periodic snapshot. On startup the snapshot
+// is loaded first, followed by any records appended to the journal since the snapshot was taken.
+type FileStorage struct {
+	path    string
+	lock    sync.Mutex
+	journal *os.File
+	writer  *bufio.Writer
+}
+
+const (
+	fileStorageSnapshot = "snapshot.jsonl"
+	fileStorageJournal  = "journal.jsonl"
+)
+
+// Open (or create) a file storage backend in the given directory
+func OpenFileStorage(path string) (*FileStorage, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create storage directory %s. %s", path, err)
+	}
+
+	journalPath := filepath.Join(path, fileStorageJournal)
+
+	// drop a partially written final record, so that the next record is appended on a line of its own
+	_, complete, err := readRecords(journalPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(journalPath); err == nil && info.Size() > complete {
+		if err := os.Truncate(journalPath, complete); err != nil {
+			return nil, fmt.Errorf("unable to truncate partially written record from storage journal. %s", err)
+		}
+	}
+
+	journal, err := os.OpenFile(journalPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to open storage journal. %s", err)
+	}
+
+	return &FileStorage{
+		path:    path,
+		journal: journal,
+		writer:  bufio.NewWriter(journal),
+	}, nil
+}
+
+func (storage *FileStorage) Load() ([]StorageRecord, error) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	records := make([]StorageRecord, 0)
+
+	for _, name := range []string{fileStorageSnapshot, fileStorageJournal} {
+		loaded, _, err := readRecords(filepath.Join(storage.path, name))
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, loaded...)
+	}
+
+	return records, nil
+}
+
+func (storage *FileStorage) Append(records ...StorageRecord) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if err := writeRecords(storage.writer, records); err != nil {
+		return fmt.Errorf("unable to append records to storage journal. %s", err)
+	}
+
+	// hand the records to the operating system right away so that they survive the process exiting unexpectedly
+	if err := storage.writer.Flush(); err != nil {
+		return fmt.Errorf("unable to write records to storage journal. %s", err)
+	}
+
+	return nil
+}
+
+func (storage *FileStorage) Snapshot(records []StorageRecord) error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	// write the snapshot to a temporary file first so that a failed snapshot never replaces a good one
+	snapshotPath := filepath.Join(storage.path, fileStorageSnapshot)
+	tempPath := snapshotPath + ".tmp"
+
+	file, err := os.Create(tempPath)
+
+	if err != nil {
+		return fmt.Errorf("unable to create storage snapshot. %s", err)
+	}
+
+	writer := bufio.NewWriter(file)
+
+	if err := writeRecords(writer, records); err == nil {
+		err = writer.Flush()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("unable to write storage snapshot. %s", err)
+	}
+
+	if err := os.Rename(tempPath, snapshotPath); err != nil {
+		return fmt.Errorf("unable to replace storage snapshot. %s", err)
+	}
+
+	// every record in the journal is now part of the snapshot. If the process stops before the journal is truncated the records
+	// are simply replayed twice, which is safe because applying a record is idempotent.
+	if err := storage.writer.Flush(); err != nil {
+		return fmt.Errorf("unable to write records to storage journal. %s", err)
+	}
+
+	if err := storage.journal.Truncate(0); err != nil {
+		return fmt.Errorf("unable to truncate storage journal. %s", err)
+	}
+
+	return nil
+}
+
+func (storage *FileStorage) Flush() error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if err := storage.writer.Flush(); err != nil {
+		return fmt.Errorf("unable to write records to storage journal. %s", err)
+	}
+
+	if err := storage.journal.Sync(); err != nil {
+		return fmt.Errorf("unable to sync storage journal. %s", err)
+	}
+
+	return nil
+}
+
+func (storage *FileStorage) Close() error {
+	if err := storage.Flush(); err != nil {
+		return err
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	return storage.journal.Close()
+}
+
+// Write records as newline delimited JSON
+func writeRecords(writer io.Writer, records []StorageRecord) error {
+	encoder := json.NewEncoder(writer)
+
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read newline delimited JSON records from a file, along with the length of the file up to the end of the last complete line. A
+// missing file contains no records, and a partially written final line (eg. from a crash during a write) is ignored.
+func readRecords(path string) ([]StorageRecord, int64, error) {
+	records := make([]StorageRecord, 0)
+	complete := int64(0)
+
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return records, 0, nil
+	}
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to open storage file %s. %s", path, err)
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				log.Printf("ignoring partially written record at line %d of storage file %s", line, path)
+			}
+
+			return records, complete, nil
+		}
+
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to read storage file %s. %s", path, err)
+		}
+
+		var record StorageRecord
+
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, 0, fmt.Errorf("invalid record at line %d of storage file %s. %s", line, path, err)
+		}
+
+		records = append(records, record)
+		complete += int64(len(data))
+	}
+}
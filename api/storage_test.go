@@ -0,0 +1,176 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStorageReplay(t *testing.T) {
+	path := t.TempDir()
+
+	storage, err := OpenFileStorage(path)
+
+	if err != nil {
+		t.Fatalf("unexpected error while opening file storage. %s", err)
+	}
+
+	idA := "ae6ad71c-e978-4e93-a8a9-5909dc3d4422"
+	idB := "98557e85-1663-4d3b-adff-bbba1d002c4e"
+
+	if err := storage.Append(StorageRecord{Type: StorageRecordReceipt, Receipt: &Receipt{Id: &idA, Retailer: "Walgreens"}}); err != nil {
+		t.Fatalf("unexpected error while appending record. %s", err)
+	}
+
+	// the snapshot replaces the journal, so only records appended afterwards remain in the journal
+	if err := storage.Snapshot([]StorageRecord{{Type: StorageRecordReceipt, Receipt: &Receipt{Id: &idA, Retailer: "Walgreens"}}}); err != nil {
+		t.Fatalf("unexpected error while writing snapshot. %s", err)
+	}
+
+	if err := storage.Append(StorageRecord{Type: StorageRecordReceipt, Receipt: &Receipt{Id: &idB, Retailer: "Target"}}); err != nil {
+		t.Fatalf("unexpected error while appending record. %s", err)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("unexpected error while closing file storage. %s", err)
+	}
+
+	// simulate a crash part way through writing a record
+	journal, err := os.OpenFile(filepath.Join(path, fileStorageJournal), os.O_APPEND|os.O_WRONLY, 0o644)
+
+	if err != nil {
+		t.Fatalf("unexpected error while opening journal. %s", err)
+	}
+
+	journal.WriteString(`{"type":"receipt","rece`)
+	journal.Close()
+
+	reopened, err := OpenFileStorage(path)
+
+	if err != nil {
+		t.Fatalf("unexpected error while reopening file storage. %s", err)
+	}
+
+	defer reopened.Close()
+
+	records, err := reopened.Load()
+
+	if err != nil {
+		t.Fatalf("unexpected error while loading records. %s", err)
+	}
+
+	if len(records) != 2 || *records[0].Receipt.Id != idA || *records[1].Receipt.Id != idB {
+		t.Errorf("expected snapshot record followed by journal record, but received %v", records)
+	}
+}
+
+func TestFileStorageAppendAfterPartialRecord(t *testing.T) {
+	path := t.TempDir()
+	idA := "ae6ad71c-e978-4e93-a8a9-5909dc3d4422"
+	idB := "98557e85-1663-4d3b-adff-bbba1d002c4e"
+
+	storage, _ := OpenFileStorage(path)
+	storage.Append(StorageRecord{Type: StorageRecordReceipt, Receipt: &Receipt{Id: &idA, Retailer: "Walgreens"}})
+	storage.Close()
+
+	// simulate a crash part way through writing a record
+	journal, _ := os.OpenFile(filepath.Join(path, fileStorageJournal), os.O_APPEND|os.O_WRONLY, 0o644)
+	journal.WriteString(`{"type":"receipt","rece`)
+	journal.Close()
+
+	// the partial record is dropped when the journal is opened, so the next record starts on a line of its own
+	reopened, err := OpenFileStorage(path)
+
+	if err != nil {
+		t.Fatalf("unexpected error while reopening file storage. %s", err)
+	}
+
+	if err := reopened.Append(StorageRecord{Type: StorageRecordReceipt, Receipt: &Receipt{Id: &idB, Retailer: "Target"}}); err != nil {
+		t.Fatalf("unexpected error while appending record. %s", err)
+	}
+
+	reopened.Close()
+
+	restored, err := OpenFileStorage(path)
+
+	if err != nil {
+		t.Fatalf("unexpected error while reopening file storage. %s", err)
+	}
+
+	defer restored.Close()
+
+	records, err := restored.Load()
+
+	if err != nil {
+		t.Fatalf("unexpected error while loading records. %s", err)
+	}
+
+	if len(records) != 2 || *records[0].Receipt.Id != idA || *records[1].Receipt.Id != idB {
+		t.Errorf("expected both complete records, but received %v", records)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	config := &Config{StorageBackend: StorageBackendFile, StoragePath: t.TempDir()}
+
+	db := SetupDatabase(config, DefaultRuleSet())
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error while closing database. %s", err)
+	}
+
+	// a request still running after shutdown never writes to the closed storage
+	written := make(chan struct{})
+
+	go func() {
+		db.InsertCustomer(&CustomerInput{Name: "Grace"})
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("expected a write after the database was closed to wait forever")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	restored := SetupDatabase(config, DefaultRuleSet())
+	defer restored.Close()
+
+	if _, err := restored.GetCustomerById(customer.Id); err != nil {
+		t.Errorf("expected the customer written before closing to be restored. %s", err)
+	}
+}
+
+func TestRestoreConcurrentReads(t *testing.T) {
+	config := &Config{StorageBackend: StorageBackendFile, StoragePath: t.TempDir(), PointsExpiryMonths: 120}
+
+	db := SetupDatabase(config, DefaultRuleSet())
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+	insertCustomerReceipt(t, db, customer.Id, "13:14")
+	db.Close()
+
+	// restored receipts are read concurrently without a write lock, so run with -race to catch readers that modify them
+	restored := SetupDatabase(config, DefaultRuleSet())
+	defer restored.Close()
+
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := restored.GetExpiringPoints(customer.Id, time.Date(2032, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+				t.Errorf("unexpected error while getting expiring points. %s", err)
+			}
+
+			restored.GetAllReceipts()
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-memdb"
+)
+
+// What a loyalty tier is based on
+const (
+	// the points awarded to the receipts of the customer, before any tier multiplier
+	TierBasisPoints = "points"
+
+	// the total spent on the receipts of the customer, less any refunds
+	TierBasisSpend = "spend"
+)
+
+// A loyalty tier reached by customers whose rolling points or spend is at least the threshold. Points awarded to the receipts
+// of customers in the tier are multiplied after every scoring rule has been applied.
+type LoyaltyTier struct {
+	Name      string
+	Threshold *big.Rat
+
+	// a decimal number greater than 0, eg. 1.25
+	Multiplier string
+}
+
+// The loyalty tiers and how customers reach them
+type TierPolicy struct {
+	// ordered by threshold, lowest first
+	Tiers []LoyaltyTier
+
+	Basis string
+
+	// how far back from a purchase the receipts of the customer count towards their tier, or 0 to count every earlier receipt
+	Window time.Duration
+}
+
+// The loyalty tier of a customer when one of their receipts was scored
+type ReceiptTier struct {
+	Name       string `json:"name"`
+	Multiplier string `json:"multiplier"`
+}
+
+// Parse loyalty tiers from a list of name=threshold:multiplier definitions separated by semicolons, eg.
+// bronze=0:1;silver=500:1.25;gold=1500:1.5. The tiers are ordered by threshold, lowest first.
+func ParseLoyaltyTiers(value string) ([]LoyaltyTier, error) {
+	tiers := make([]LoyaltyTier, 0)
+	names := make(map[string]bool)
+
+	for _, part := range strings.Split(value, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		name, definition, ok := strings.Cut(part, "=")
+		threshold, multiplier, found := strings.Cut(definition, ":")
+		name = strings.TrimSpace(name)
+
+		if !ok || !found || name == "" {
+			return nil, fmt.Errorf("invalid loyalty tier %s, expected name=threshold:multiplier", part)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("loyalty tier %s is defined more than once", name)
+		}
+
+		names[name] = true
+
+		tier := LoyaltyTier{Name: name}
+		var valid bool
+
+		if tier.Threshold, valid = new(big.Rat).SetString(strings.TrimSpace(threshold)); !valid || tier.Threshold.Sign() < 0 {
+			return nil, fmt.Errorf("invalid threshold for loyalty tier %s, expected a number of at least 0", name)
+		}
+
+		tier.Multiplier = strings.TrimSpace(multiplier)
+
+		if parsed, valid := new(big.Rat).SetString(tier.Multiplier); !valid || parsed.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid multiplier for loyalty tier %s, expected a number greater than 0", name)
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].Threshold.Cmp(tiers[j].Threshold) < 0
+	})
+
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Threshold.Cmp(tiers[i-1].Threshold) == 0 {
+			return nil, fmt.Errorf("loyalty tiers %s and %s have the same threshold", tiers[i-1].Name, tiers[i].Name)
+		}
+	}
+
+	return tiers, nil
+}
+
+// Build a tier policy from configuration. Customers earn points without a multiplier when no tiers are defined.
+func NewTierPolicy(tiers string, basis string, window time.Duration) (TierPolicy, error) {
+	parsed, err := ParseLoyaltyTiers(tiers)
+
+	if err != nil || len(parsed) == 0 {
+		return TierPolicy{}, err
+	}
+
+	if basis != TierBasisPoints && basis != TierBasisSpend {
+		return TierPolicy{}, fmt.Errorf("invalid loyalty tier basis %s, expected either %s or %s", basis, TierBasisPoints, TierBasisSpend)
+	}
+
+	if window < 0 {
+		return TierPolicy{}, fmt.Errorf("invalid loyalty tier window %s", window)
+	}
+
+	return TierPolicy{Tiers: parsed, Basis: basis, Window: window}, nil
+}
+
+// Multiply points by the tier multiplier, rounding down to a whole number of points
+func (tier *ReceiptTier) Apply(points int) (int, error) {
+	multiplier, valid := new(big.Rat).SetString(tier.Multiplier)
+
+	if !valid {
+		return 0, fmt.Errorf("invalid multiplier %s for loyalty tier %s", tier.Multiplier, tier.Name)
+	}
+
+	product := new(big.Rat).Mul(big.NewRat(int64(points), 1), multiplier)
+	rounded := new(big.Int).Div(product.Num(), product.Denom())
+
+	if !rounded.IsInt64() || rounded.Int64() > int64(^uint32(0)>>1) {
+		return 0, fmt.Errorf("points multiplied by loyalty tier %s are out of range", tier.Name)
+	}
+
+	return int(rounded.Int64()), nil
+}
+
+// Get the rolling points or spend of a customer from the receipts they purchased within the window before a time. Voided,
+// refunded and reversed receipts don't count, and neither does the excluded receipt, which is the one being scored.
+func (policy TierPolicy) rollingValue(txn *memdb.Txn, customerId string, excludeId string, at time.Time) (*big.Rat, error) {
+	lots, err := customerLots(txn, customerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	value := new(big.Rat)
+
+	for _, lot := range lots {
+		if lot.Reversed || lot.ReceiptId == excludeId {
+			continue
+		}
+
+		raw, err := txn.First("receipt", "id", lot.ReceiptId)
+
+		if err != nil {
+			return nil, fmt.Errorf("error while querying database for receipt %s. %s", lot.ReceiptId, err)
+		}
+
+		if raw == nil || raw.(*Receipt).IsClosed() {
+			continue
+		}
+
+		receipt := raw.(*Receipt)
+		purchased, err := receipt.GetPurchaseDatetime()
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse purchase date & time of receipt %s. %s", lot.ReceiptId, err)
+		}
+
+		if !purchased.Before(at) || (policy.Window > 0 && purchased.Before(at.Add(-policy.Window))) {
+			continue
+		}
+
+		switch policy.Basis {
+		case TierBasisSpend:
+			total, err := receipt.GetRemaining().GetPurchaseTotal()
+
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse total of receipt %s. %s", lot.ReceiptId, err)
+			}
+
+			value.Add(value, big.NewRat(total.Cents(), 100))
+		default:
+			points := receipt.Points
+
+			if receipt.BasePoints != nil {
+				points = receipt.BasePoints
+			}
+
+			if points != nil {
+				value.Add(value, big.NewRat(int64(*points), 1))
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// Get the highest loyalty tier reached by a customer at a time, or nil if they haven't reached any tier
+func (policy TierPolicy) tierAt(txn *memdb.Txn, customerId string, excludeId string, at time.Time) (*ReceiptTier, error) {
+	if len(policy.Tiers) == 0 || customerId == "" {
+		return nil, nil
+	}
+
+	value, err := policy.rollingValue(txn, customerId, excludeId, at)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var reached *ReceiptTier
+
+	for _, tier := range policy.Tiers {
+		if value.Cmp(tier.Threshold) >= 0 {
+			reached = &ReceiptTier{Name: tier.Name, Multiplier: tier.Multiplier}
+		}
+	}
+
+	return reached, nil
+}
+
+// Get the loyalty tier of the customer of a receipt at the time of purchase, or nil if the receipt has no customer or they
+// haven't reached any tier
+func (policy TierPolicy) receiptTier(txn *memdb.Txn, receipt *Receipt) (*ReceiptTier, error) {
+	if len(policy.Tiers) == 0 || receipt.CustomerId == "" {
+		return nil, nil
+	}
+
+	purchased, err := receipt.GetPurchaseDatetime()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse purchase date & time of receipt %s. %s", receipt.GetId(), err)
+	}
+
+	return policy.tierAt(txn, receipt.CustomerId, receipt.GetId(), *purchased)
+}
+
+// Get the loyalty tier the customer of a receipt would have for it, without storing the receipt
+func (db ReceiptDatabase) GetReceiptTier(receipt *Receipt) (*ReceiptTier, error) {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	return db.Tiers.receiptTier(txn, receipt)
+}
+
+// Get the loyalty tier a customer has reached at a time, or nil if they haven't reached any tier
+func (db ReceiptDatabase) GetCustomerTier(customerId string, at time.Time) (*ReceiptTier, error) {
+	txn := db.MemDB.Txn(false)
+	defer txn.Abort()
+
+	return db.Tiers.tierAt(txn, customerId, "", at)
+}
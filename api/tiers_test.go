@@ -0,0 +1,128 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLoyaltyTiers(t *testing.T) {
+	tiers, err := ParseLoyaltyTiers("gold=1500:1.5; bronze=0:1;silver=500.50:1.25;")
+
+	if err != nil {
+		t.Fatalf("unexpected error while parsing loyalty tiers. %s", err)
+	}
+
+	if len(tiers) != 3 || tiers[0].Name != "bronze" || tiers[1].Name != "silver" || tiers[2].Name != "gold" {
+		t.Fatalf("expected tiers ordered by threshold, but received %v", tiers)
+	}
+
+	if tiers[1].Threshold.FloatString(2) != "500.50" || tiers[1].Multiplier != "1.25" {
+		t.Errorf("expected silver to start at 500.50 with a multiplier of 1.25, but received %v", tiers[1])
+	}
+
+	invalid := []string{
+		"bronze",
+		"bronze=0",
+		"=0:1",
+		"bronze=-1:1",
+		"bronze=0:0",
+		"bronze=0:x",
+		"bronze=0:1;bronze=10:2",
+		"bronze=0:1;silver=0:2",
+	}
+
+	for _, value := range invalid {
+		if _, err := ParseLoyaltyTiers(value); err == nil {
+			t.Errorf("expected error while parsing loyalty tiers %s, but received nil", value)
+		}
+	}
+
+	if _, err := NewTierPolicy("bronze=0:1", "visits", 0); err == nil {
+		t.Error("expected error while building a tier policy with an unknown basis, but received nil")
+	}
+}
+
+func TestReceiptTierApply(t *testing.T) {
+	tests := map[string]int{"1": 31, "1.25": 38, "1.5": 46, "0.5": 15}
+
+	for multiplier, expected := range tests {
+		if points, err := (&ReceiptTier{Name: "test", Multiplier: multiplier}).Apply(31); err != nil || points != expected {
+			t.Errorf("expected %d points with a multiplier of %s, but received %d. %v", expected, multiplier, points, err)
+		}
+	}
+}
+
+func TestLoyaltyTiers(t *testing.T) {
+	db := setupTestDatabase(t)
+	db.Tiers, _ = NewTierPolicy("bronze=0:1;silver=50:1.25;gold=100:1.5", TierBasisPoints, 24*time.Hour)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	// each receipt earns 31 base points, and the tier only counts receipts purchased earlier
+	expected := []struct {
+		time   string
+		tier   string
+		points int
+	}{
+		{"09:13", "bronze", 31},
+		{"10:13", "bronze", 31},
+		{"11:13", "silver", 38},
+		{"12:13", "silver", 38},
+		{"13:14", "gold", 46},
+		{"08:13", "bronze", 31},
+	}
+
+	var gold *Receipt
+
+	for _, test := range expected {
+		receipt := insertCustomerReceipt(t, db, customer.Id, test.time)
+
+		if test.tier == "gold" {
+			gold = receipt
+		}
+
+		if receipt.Tier == nil || receipt.Tier.Name != test.tier || *receipt.Points != test.points || *receipt.BasePoints != 31 {
+			t.Errorf("expected a %s receipt at %s with %d points, but received %v and %v", test.tier, test.time, test.points, receipt.Tier, *receipt.Points)
+		}
+	}
+
+	if balance, _, _ := db.GetCustomerBalance(customer.Id); balance != 215 {
+		t.Errorf("expected a balance of 215, but received %d", balance)
+	}
+
+	// the breakdown explains the multiplied points
+	breakdown, _ := DefaultRuleSet().GetPointsBreakdown(gold)
+
+	if breakdown.BasePoints != 31 || breakdown.Points != 46 || breakdown.Tier.Name != "gold" {
+		t.Errorf("expected 31 base points multiplied to 46, but received %v", breakdown)
+	}
+
+	// the rolling window has long passed, so the customer is back to the lowest tier today
+	if tier, err := db.GetCustomerTier(customer.Id, time.Now()); err != nil || tier.Name != "bronze" {
+		t.Errorf("expected bronze outside of the window, but received %v. %v", tier, err)
+	}
+
+	// receipts without a customer are never multiplied
+	if receipt, _ := db.GetReceiptById(exampleIdB); receipt.Tier != nil || *receipt.BasePoints != *receipt.Points {
+		t.Errorf("expected a receipt without a customer to have no tier, but received %v", receipt.Tier)
+	}
+}
+
+func TestLoyaltyTiersBySpend(t *testing.T) {
+	db := setupTestDatabase(t)
+	db.Tiers, _ = NewTierPolicy("silver=2.50:2", TierBasisSpend, 0)
+
+	customer, _ := db.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	// each receipt spends 1.25, so the third receipt is the first in the tier
+	insertCustomerReceipt(t, db, customer.Id, "09:13")
+	insertCustomerReceipt(t, db, customer.Id, "10:13")
+
+	if receipt := insertCustomerReceipt(t, db, customer.Id, "11:13"); receipt.Tier == nil || *receipt.Points != 62 {
+		t.Errorf("expected a silver receipt with 62 points, but received %v", receipt.Tier)
+	}
+
+	if tier, _ := db.GetCustomerTier(customer.Id, time.Now()); tier == nil || tier.Name != "silver" {
+		t.Errorf("expected the customer to be silver without a window, but received %v", tier)
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var timeWindowPattern = regexp.MustCompile(`^([\[(])\s*([0-9]{2}):([0-9]{2})\s*,\s*([0-9]{2}):([0-9]{2})\s*([\])])$`)
+
+// A window of local time with minute precision, written in interval notation where square brackets include the boundary and
+// round brackets exclude it, eg. (14:00,16:00) or [17:00,19:00). A window that ends before it starts wraps past midnight.
+type TimeWindow struct {
+	Start          int
+	End            int
+	StartInclusive bool
+	EndInclusive   bool
+}
+
+// Parse a time window written in interval notation. The end of the window may be 24:00 to include times up to midnight.
+func ParseTimeWindow(value string) (TimeWindow, error) {
+	match := timeWindowPattern.FindStringSubmatch(strings.TrimSpace(value))
+
+	if match == nil {
+		return TimeWindow{}, fmt.Errorf("invalid time window %s, expected interval notation such as (14:00,16:00)", value)
+	}
+
+	start, err := parseWindowMinute(match[2], match[3])
+
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid start of time window %s. %s", value, err)
+	}
+
+	end, err := parseWindowMinute(match[4], match[5])
+
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid end of time window %s. %s", value, err)
+	}
+
+	if start == 24*60 {
+		return TimeWindow{}, fmt.Errorf("invalid start of time window %s. a window can not start at 24:00", value)
+	}
+
+	return TimeWindow{
+		Start:          start,
+		End:            end,
+		StartInclusive: match[1] == "[",
+		EndInclusive:   match[6] == "]",
+	}, nil
+}
+
+// Convert hours and minutes into minutes since midnight
+func parseWindowMinute(hours string, minutes string) (int, error) {
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+
+	if h > 24 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("%s:%s is not a valid time", hours, minutes)
+	}
+
+	return h*60 + m, nil
+}
+
+// Check if a time, given in minutes since midnight, falls within the window
+func (window TimeWindow) Contains(minute int) bool {
+	afterStart := minute > window.Start || (window.StartInclusive && minute == window.Start)
+	beforeEnd := minute < window.End || (window.EndInclusive && minute == window.End)
+
+	if window.End < window.Start {
+		return afterStart || beforeEnd
+	}
+
+	return afterStart && beforeEnd
+}
+
+func (window TimeWindow) String() string {
+	open, close := "(", ")"
+
+	if window.StartInclusive {
+		open = "["
+	}
+
+	if window.EndInclusive {
+		close = "]"
+	}
+
+	return fmt.Sprintf("%s%02d:%02d,%02d:%02d%s", open, window.Start/60, window.Start%60, window.End/60, window.End%60, close)
+}
+
+// Parse a list of time window rules separated by semicolons, where each rule is written as name=window:points, eg.
+// afternoon-purchase=(14:00,16:00):10;happy-hour=[17:00,19:00):5. This is a shorthand for declarative rules with a
+// purchaseTime window condition and flat points, so each rule is compiled exactly like the same rule in a rule file.
+func ParseTimeWindowRules(value string) ([]*DeclarativeRule, error) {
+	rules := make([]*DeclarativeRule, 0)
+
+	for _, part := range strings.Split(value, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		name, definition, ok := strings.Cut(part, "=")
+		separator := strings.LastIndex(definition, ":")
+
+		if !ok || separator < 0 || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid time window rule %s, expected name=window:points", part)
+		}
+
+		window, err := ParseTimeWindow(definition[:separator])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window rule %s. %s", part, err)
+		}
+
+		points, err := strconv.Atoi(strings.TrimSpace(definition[separator+1:]))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid points for time window rule %s. %s", part, err)
+		}
+
+		rule, err := CompileRuleDefinition(RuleDefinition{
+			Name:        strings.TrimSpace(name),
+			Description: fmt.Sprintf("%d points if the time of purchase is within %s", points, window),
+			When:        &ReceiptCondition{PurchaseTime: &TimeCondition{Window: window.String()}},
+			Points:      PointsAction{Flat: &points},
+			Reason:      fmt.Sprintf("purchase time {{.PurchaseTime}} is within %s", window),
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window rule %s. %s", part, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestAfternoonPurchaseBoundaries(t *testing.T) {
+	rules, err := NewRuleSet([]string{"afternoon-purchase"})
+
+	if err != nil {
+		t.Fatalf("unexpected error while building rule set. %s", err)
+	}
+
+	// after 2:00pm and before 4:00pm, so both boundaries are excluded
+	cases := map[string]int{
+		"00:00": 0,
+		"13:59": 0,
+		"14:00": 0,
+		"14:01": 10,
+		"15:00": 10,
+		"15:59": 10,
+		"16:00": 0,
+		"16:01": 0,
+		"16:59": 0,
+		"23:59": 0,
+	}
+
+	for purchaseTime, expected := range cases {
+		receipt := &Receipt{
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: purchaseTime,
+		}
+
+		points, err := rules.GetPoints(receipt)
+
+		if err != nil {
+			t.Fatalf("unexpected error while calculating points. %s", err)
+		}
+
+		if points != expected {
+			t.Errorf("expected %d points at %s, but received %d instead", expected, purchaseTime, points)
+		}
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	cases := []struct {
+		window  string
+		inside  []string
+		outside []string
+	}{
+		{
+			window:  "(14:00,16:00)",
+			inside:  []string{"14:01", "15:59"},
+			outside: []string{"14:00", "16:00"},
+		},
+		{
+			window:  "[14:00,16:00]",
+			inside:  []string{"14:00", "16:00"},
+			outside: []string{"13:59", "16:01"},
+		},
+		{
+			window:  "[17:00,19:00)",
+			inside:  []string{"17:00", "18:59"},
+			outside: []string{"16:59", "19:00"},
+		},
+		{
+			window:  "(17:00,19:00]",
+			inside:  []string{"17:01", "19:00"},
+			outside: []string{"17:00", "19:01"},
+		},
+		{
+			window:  "[22:00,02:00)",
+			inside:  []string{"22:00", "23:59", "00:00", "01:59"},
+			outside: []string{"21:59", "02:00", "12:00"},
+		},
+		{
+			window:  "[23:00,24:00]",
+			inside:  []string{"23:00", "23:59"},
+			outside: []string{"22:59", "00:00"},
+		},
+	}
+
+	for _, test := range cases {
+		window, err := ParseTimeWindow(test.window)
+
+		if err != nil {
+			t.Fatalf("unexpected error while parsing time window %s. %s", test.window, err)
+		}
+
+		if window.String() != test.window {
+			t.Errorf("expected time window %s to be written as %s, but received %s", test.window, test.window, window)
+		}
+
+		for _, value := range test.inside {
+			if minute, _ := parseWindowMinute(value[:2], value[3:]); !window.Contains(minute) {
+				t.Errorf("expected %s to be within %s", value, test.window)
+			}
+		}
+
+		for _, value := range test.outside {
+			if minute, _ := parseWindowMinute(value[:2], value[3:]); window.Contains(minute) {
+				t.Errorf("expected %s to be outside of %s", value, test.window)
+			}
+		}
+	}
+
+	for _, value := range []string{"14:00,16:00", "[14:00,16:00", "[14:60,16:00]", "[25:00,16:00]", "[24:00,01:00)", "[2:00,3:00]"} {
+		if _, err := ParseTimeWindow(value); err == nil {
+			t.Errorf("expected error while parsing time window %s, but received nil", value)
+		}
+	}
+}
+
+func TestParseTimeWindowRules(t *testing.T) {
+	rules, err := ParseTimeWindowRules("afternoon-purchase=[14:00,16:00):10; happy-hour=[17:00,19:00):5;")
+
+	if err != nil {
+		t.Fatalf("unexpected error while parsing time window rules. %s", err)
+	}
+
+	if len(rules) != 2 || rules[1].Name() != "happy-hour" || rules[1].Definition.When.PurchaseTime.Window != "[17:00,19:00)" {
+		t.Errorf("expected two time window rules, but received %v", rules)
+	}
+
+	// the rules are declarative rules, so they score and explain points like a rule file
+	for purchaseTime, expected := range map[string]int{"17:00": 5, "18:59": 5, "19:00": 0} {
+		results, err := rules[1].Evaluate(&Receipt{PurchaseDate: "2022-01-02", PurchaseTime: purchaseTime})
+
+		if err != nil {
+			t.Fatalf("unexpected error while evaluating time window rule. %s", err)
+		}
+
+		if points := len(results) * 5; points != expected {
+			t.Errorf("expected %d points at %s, but received %v", expected, purchaseTime, results)
+		}
+	}
+
+	for _, value := range []string{"happy-hour", "happy-hour=[17:00,19:00)", "happy-hour=[17:00,19:00):five", "=[17:00,19:00):5", "Happy=[17:00,19:00):5"} {
+		if _, err := ParseTimeWindowRules(value); err == nil {
+			t.Errorf("expected error while parsing time window rules %s, but received nil", value)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timezoneOffsetPattern = regexp.MustCompile(`^([+-])([0-9]{2}):?([0-9]{2})?$`)
+
+// Parse a timezone given as either an IANA name (eg. America/Chicago) or a fixed UTC offset (eg. -05:00, +0530 or Z)
+func ParseTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Z" || strings.EqualFold(name, "UTC") {
+		return time.UTC, nil
+	}
+
+	if match := timezoneOffsetPattern.FindStringSubmatch(name); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes := 0
+
+		if match[3] != "" {
+			minutes, _ = strconv.Atoi(match[3])
+		}
+
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("invalid UTC offset %s", name)
+		}
+
+		offset := hours*60*60 + minutes*60
+
+		if match[1] == "-" {
+			offset = -offset
+		}
+
+		return time.FixedZone(name, offset), nil
+	}
+
+	location, err := time.LoadLocation(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %s", name)
+	}
+
+	return location, nil
+}
+
+// Get the timezone used for receipts from a retailer that do not include one, falling back to the default timezone
+func (config *Config) GetRetailerTimezone(retailer string) string {
+	if timezone, ok := config.RetailerTimezones[strings.ToLower(strings.TrimSpace(retailer))]; ok {
+		return timezone
+	}
+
+	return config.DefaultTimezone
+}
+
+// Check that every configured timezone can be loaded
+func ValidateTimezones(config *Config) error {
+	if _, err := ParseTimezone(config.DefaultTimezone); err != nil {
+		return err
+	}
+
+	for retailer, timezone := range config.RetailerTimezones {
+		if _, err := ParseTimezone(timezone); err != nil {
+			return fmt.Errorf("%s for retailer %s", err, retailer)
+		}
+	}
+
+	return nil
+}
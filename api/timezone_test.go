@@ -0,0 +1,97 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimezone(t *testing.T) {
+	cases := map[string]int{
+		"":                0,
+		"Z":               0,
+		"UTC":             0,
+		"-05:00":          -5 * 60 * 60,
+		"+0530":           5*60*60 + 30*60,
+		"+09":             9 * 60 * 60,
+		"America/Chicago": -6 * 60 * 60,
+	}
+
+	for name, expected := range cases {
+		location, err := ParseTimezone(name)
+
+		if err != nil {
+			t.Fatalf("unexpected error while parsing timezone %s. %s", name, err)
+		}
+
+		if _, offset := time.Date(2022, time.January, 1, 0, 0, 0, 0, location).Zone(); offset != expected {
+			t.Errorf("expected offset %d for timezone %s, but received %d instead", expected, name, offset)
+		}
+	}
+
+	for _, name := range []string{"Mars/Olympus", "+25:00", "-05:75", "05:00"} {
+		if _, err := ParseTimezone(name); err == nil {
+			t.Errorf("expected error while parsing timezone %s, but received nil", name)
+		}
+	}
+}
+
+func TestGetPurchaseDatetimeInTimezone(t *testing.T) {
+	a := &Receipt{
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "15:00",
+		Timezone:     "America/Chicago",
+	}
+
+	at, err := a.GetPurchaseDatetime()
+
+	if err != nil {
+		t.Fatalf("unexpected error while parsing purchase datetime. %s", err)
+	}
+
+	// the local time is kept for scoring, while the instant is correct
+	if at.Hour() != 15 || at.UTC().Hour() != 21 {
+		t.Errorf("expected 15:00 local and 21:00 UTC, but received %s", at)
+	}
+}
+
+func TestValidateReceiptTimezoneDefaults(t *testing.T) {
+	api := testApi(ConsistencyPolicyFlag)
+	api.Config.DefaultTimezone = "UTC"
+	api.Config.RetailerTimezones = map[string]string{"target": "America/Chicago"}
+
+	receipt := func(retailer string, timezone string) *Receipt {
+		return &Receipt{
+			Retailer:      retailer,
+			PurchaseDate:  "2022-01-01",
+			PurchaseTime:  "13:01",
+			PurchaseTotal: "1.25",
+			Timezone:      timezone,
+			Items:         []ReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+		}
+	}
+
+	cases := []struct {
+		receipt  *Receipt
+		expected string
+	}{
+		{receipt: receipt("Target", ""), expected: "America/Chicago"},
+		{receipt: receipt("Walgreens", ""), expected: "UTC"},
+		{receipt: receipt("Target", "-05:00"), expected: "-05:00"},
+	}
+
+	for _, test := range cases {
+		if errors := api.ValidateReceipt(test.receipt); len(errors) > 0 {
+			t.Fatalf("unexpected validation errors %v", errors)
+		}
+
+		if test.receipt.Timezone != test.expected {
+			t.Errorf("expected timezone %s for %s, but received %s instead", test.expected, test.receipt.Retailer, test.receipt.Timezone)
+		}
+	}
+
+	errors := api.ValidateReceipt(receipt("Target", "Mars/Olympus"))
+
+	if len(errors) != 1 || errors[0].Path != "/timezone" {
+		t.Errorf("expected a /timezone validation error, but received %v instead", errors)
+	}
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Validation error codes
+const (
+	ValidationInvalidJson     = "invalid_json"
+	ValidationInvalidType     = "invalid_type"
+	ValidationRequired        = "required"
+	ValidationInvalidFormat   = "invalid_format"
+	ValidationTooFewItems     = "too_few_items"
+	ValidationTotalMismatch   = "total_mismatch"
+	ValidationInvalidDate     = "invalid_date"
+	ValidationInvalidTime     = "invalid_time"
+	ValidationFuturePurchase  = "future_purchase"
+	ValidationExpiredPurchase = "expired_purchase"
+	ValidationUnknownCustomer = "unknown_customer"
+)
+
+var (
+	retailerPattern         = regexp.MustCompile(`^[\w\s\-&]+$`)
+	purchaseDatePattern     = regexp.MustCompile(`^[0-9]{4}\-[0-1][0-9]\-[0-3][0-9]$`)
+	purchaseTimePattern     = regexp.MustCompile(`^[0-2][0-9]:[0-5][0-9]$`)
+	shortDescriptionPattern = regexp.MustCompile(`^[\w\s\-]+$`)
+)
+
+// A single problem found while validating a receipt. The path is a JSON pointer to the offending field.
+type ValidationError struct {
+	Path     string `json:"path"`
+	Code     string `json:"code"`
+	Value    any    `json:"value,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Report validation errors using the JSON field names (eg. `purchaseDate` instead of `PurchaseDate`)
+func init() {
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+			if name == "-" {
+				return ""
+			}
+
+			return name
+		})
+	}
+}
+
+// Validate the format of every receipt field, returning all problems that were found. When every amount is valid, the item prices
+// are also compared to the total and the result is recorded on the receipt.
+func (api ReceiptsApi) ValidateReceipt(receipt *Receipt) []ValidationError {
+	errors := make([]ValidationError, 0)
+
+	// validate retailer
+	if match := retailerPattern.MatchString(receipt.Retailer); !match {
+		errors = append(errors, ValidationError{
+			Path:     "/retailer",
+			Code:     ValidationInvalidFormat,
+			Value:    receipt.Retailer,
+			Expected: retailerPattern.String(),
+			Message:  "invalid retailer",
+		})
+	}
+
+	// validate purchase date, which must also be a real calendar date
+	if match := purchaseDatePattern.MatchString(receipt.PurchaseDate); !match {
+		errors = append(errors, ValidationError{
+			Path:     "/purchaseDate",
+			Code:     ValidationInvalidFormat,
+			Value:    receipt.PurchaseDate,
+			Expected: purchaseDatePattern.String(),
+			Message:  "invalid purchaseDate value",
+		})
+	} else if _, err := time.Parse("2006-01-02", receipt.PurchaseDate); err != nil {
+		errors = append(errors, ValidationError{
+			Path:     "/purchaseDate",
+			Code:     ValidationInvalidDate,
+			Value:    receipt.PurchaseDate,
+			Expected: "a calendar date",
+			Message:  "purchaseDate is not a calendar date",
+		})
+	}
+
+	// validate purchase time, which must also be a real time of day
+	if match := purchaseTimePattern.MatchString(receipt.PurchaseTime); !match {
+		errors = append(errors, ValidationError{
+			Path:     "/purchaseTime",
+			Code:     ValidationInvalidFormat,
+			Value:    receipt.PurchaseTime,
+			Expected: purchaseTimePattern.String(),
+			Message:  "invalid purchaseTime value",
+		})
+	} else if _, err := time.Parse("15:04", receipt.PurchaseTime); err != nil {
+		errors = append(errors, ValidationError{
+			Path:     "/purchaseTime",
+			Code:     ValidationInvalidTime,
+			Value:    receipt.PurchaseTime,
+			Expected: "a time of day between 00:00 and 23:59",
+			Message:  "purchaseTime is not a time of day",
+		})
+	}
+
+	// validate timezone, using the default for the retailer when the receipt does not include one
+	if receipt.Timezone == "" {
+		receipt.Timezone = api.Config.GetRetailerTimezone(receipt.Retailer)
+	}
+
+	if _, err := ParseTimezone(receipt.Timezone); err != nil {
+		errors = append(errors, ValidationError{
+			Path:     "/timezone",
+			Code:     ValidationInvalidFormat,
+			Value:    receipt.Timezone,
+			Expected: "an IANA timezone name or UTC offset",
+			Message:  "invalid timezone value",
+		})
+	}
+
+	// validate that the purchase happened within the accepted range, once the date, time and timezone are known to be valid
+	if len(errors) == 0 {
+		errors = append(errors, api.validatePurchaseDatetime(receipt, time.Now())...)
+	}
+
+	// validate customer, which must already exist. Customers are never removed, so the customer still exists when the receipt
+	// is inserted.
+	if receipt.CustomerId != "" {
+		if !idPattern.MatchString(receipt.CustomerId) {
+			errors = append(errors, ValidationError{
+				Path:     "/customerId",
+				Code:     ValidationInvalidFormat,
+				Value:    receipt.CustomerId,
+				Expected: idPattern.String(),
+				Message:  "invalid customerId value",
+			})
+		} else if _, err := api.Database.GetCustomerById(receipt.CustomerId); err != nil {
+			errors = append(errors, ValidationError{
+				Path:     "/customerId",
+				Code:     ValidationUnknownCustomer,
+				Value:    receipt.CustomerId,
+				Expected: "the id of an existing customer",
+				Message:  "no customer exists with this customerId",
+			})
+		}
+	}
+
+	// validate total
+	if _, err := receipt.GetPurchaseTotal(); err != nil {
+		errors = append(errors, ValidationError{
+			Path:     "/total",
+			Code:     ValidationInvalidFormat,
+			Value:    receipt.PurchaseTotal,
+			Expected: moneyPattern.String(),
+			Message:  "invalid total value",
+		})
+	}
+
+	// validate receipt item count
+	if len(receipt.Items) < 1 {
+		errors = append(errors, ValidationError{
+			Path:     "/items",
+			Code:     ValidationTooFewItems,
+			Expected: "at least 1 item",
+			Message:  "at least one receipt item must be provided",
+		})
+	}
+
+	// validate each receipt item
+	for i := range receipt.Items {
+		item := &receipt.Items[i]
+
+		// validate receipt item description
+		if match := shortDescriptionPattern.MatchString(item.ShortDescription); !match {
+			errors = append(errors, ValidationError{
+				Path:     fmt.Sprintf("/items/%d/shortDescription", i),
+				Code:     ValidationInvalidFormat,
+				Value:    item.ShortDescription,
+				Expected: shortDescriptionPattern.String(),
+				Message:  fmt.Sprintf("invalid shortDescription value for receipt item %d", i),
+			})
+		}
+
+		// validate receipt item price
+		if _, err := item.GetPrice(); err != nil {
+			errors = append(errors, ValidationError{
+				Path:     fmt.Sprintf("/items/%d/price", i),
+				Code:     ValidationInvalidFormat,
+				Value:    item.Price,
+				Expected: moneyPattern.String(),
+				Message:  fmt.Sprintf("invalid price value for receipt item %d", i),
+			})
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+
+	// validate that the item prices add up to the total
+	consistency, reject, err := CheckConsistency(receipt, api.Config.ConsistencyPolicy, api.Config.ConsistencyTaxTolerance)
+
+	if err != nil {
+		errors = append(errors, ValidationError{
+			Path:    "/total",
+			Code:    ValidationInvalidFormat,
+			Value:   receipt.PurchaseTotal,
+			Message: fmt.Sprintf("unable to compare item prices to total. %s", err),
+		})
+
+		return errors
+	}
+
+	receipt.Consistency = consistency
+
+	if reject {
+		expected := fmt.Sprintf("%s, the sum of the item prices", consistency.ItemsTotal)
+
+		if api.Config.ConsistencyPolicy == ConsistencyPolicyTolerance {
+			expected = fmt.Sprintf("%s plus up to %d%% tax", expected, api.Config.ConsistencyTaxTolerance)
+		}
+
+		errors = append(errors, ValidationError{
+			Path:     "/total",
+			Code:     ValidationTotalMismatch,
+			Value:    receipt.PurchaseTotal,
+			Expected: expected,
+			Message:  fmt.Sprintf("total does not match the item prices, discrepancy of %s", consistency.Discrepancy),
+		})
+	}
+
+	return errors
+}
+
+// Validate that a purchase is not in the future, allowing for clock skew, and is not older than the retention age
+func (api ReceiptsApi) validatePurchaseDatetime(receipt *Receipt, now time.Time) []ValidationError {
+	purchaseDatetime, err := receipt.GetPurchaseDatetime()
+
+	if err != nil {
+		return []ValidationError{
+			{
+				Path:    "/purchaseDate",
+				Code:    ValidationInvalidDate,
+				Value:   receipt.PurchaseDate,
+				Message: fmt.Sprintf("unable to parse purchase date & time. %s", err),
+			},
+		}
+	}
+
+	if latest := now.Add(api.Config.PurchaseFutureSkew); purchaseDatetime.After(latest) {
+		return []ValidationError{
+			{
+				Path:     "/purchaseDate",
+				Code:     ValidationFuturePurchase,
+				Value:    receipt.PurchaseDate,
+				Expected: fmt.Sprintf("no later than %s", latest.In(purchaseDatetime.Location()).Format("2006-01-02 15:04")),
+				Message:  "purchase date & time is in the future",
+			},
+		}
+	}
+
+	if api.Config.PurchaseMaxAge > 0 {
+		if earliest := now.Add(-api.Config.PurchaseMaxAge); purchaseDatetime.Before(earliest) {
+			return []ValidationError{
+				{
+					Path:     "/purchaseDate",
+					Code:     ValidationExpiredPurchase,
+					Value:    receipt.PurchaseDate,
+					Expected: fmt.Sprintf("no earlier than %s", earliest.In(purchaseDatetime.Location()).Format("2006-01-02 15:04")),
+					Message:  "purchase date & time is older than the retention age",
+				},
+			}
+		}
+	}
+
+	return nil
+}
+
+// Convert an error returned while binding a request body into validation errors
+func bindingValidationErrors(err error) []ValidationError {
+	var fieldErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+
+	switch {
+	case errors.As(err, &fieldErrors):
+		validationErrors := make([]ValidationError, 0, len(fieldErrors))
+
+		for _, fieldError := range fieldErrors {
+			// the namespace includes the root struct name, eg. Receipt.items[0].price
+			_, namespace, _ := strings.Cut(fieldError.Namespace(), ".")
+			path := namespacePointer(namespace)
+
+			validationErrors = append(validationErrors, ValidationError{
+				Path:    path,
+				Code:    fieldError.Tag(),
+				Message: fmt.Sprintf("%s failed the %s validation", path, fieldError.Tag()),
+			})
+		}
+
+		return validationErrors
+	case errors.As(err, &typeError):
+		path := "/" + strings.ReplaceAll(typeError.Field, ".", "/")
+
+		return []ValidationError{
+			{
+				Path:     path,
+				Code:     ValidationInvalidType,
+				Value:    typeError.Value,
+				Expected: typeError.Type.String(),
+				Message:  fmt.Sprintf("%s must be a %s", path, typeError.Type),
+			},
+		}
+	case errors.As(err, &syntaxError):
+		return []ValidationError{
+			{
+				Path:    "",
+				Code:    ValidationInvalidJson,
+				Message: fmt.Sprintf("invalid JSON at offset %d. %s", syntaxError.Offset, syntaxError),
+			},
+		}
+	default:
+		return []ValidationError{
+			{
+				Path:    "",
+				Code:    ValidationInvalidJson,
+				Message: err.Error(),
+			},
+		}
+	}
+}
+
+// Convert a validator namespace such as items[0].price into a JSON pointer such as /items/0/price
+func namespacePointer(namespace string) string {
+	replacer := strings.NewReplacer("[", "/", "]", "", ".", "/")
+
+	return "/" + replacer.Replace(namespace)
+}
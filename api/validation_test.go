@@ -0,0 +1,244 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func testApi(policy string) ReceiptsApi {
+	return ReceiptsApi{
+		Config: &Config{
+			ConsistencyPolicy:       policy,
+			ConsistencyTaxTolerance: 10,
+		},
+	}
+}
+
+func TestValidateReceiptPaths(t *testing.T) {
+	api := testApi(ConsistencyPolicyFlag)
+
+	a := &Receipt{
+		Retailer:      "Target!",
+		PurchaseDate:  "2022-01-01",
+		PurchaseTime:  "13:01",
+		PurchaseTotal: "35.3",
+		Items: []ReceiptItem{
+			{
+				ShortDescription: "Mountain Dew 12PK",
+				Price:            "6.49",
+			},
+			{
+				ShortDescription: "Emils Cheese Pizza",
+				Price:            "12.25",
+			},
+			{
+				ShortDescription: "Knorr Creamy Chicken",
+				Price:            "1.2",
+			},
+		},
+	}
+
+	errors := api.ValidateReceipt(a)
+
+	expected := []string{"/retailer", "/total", "/items/2/price"}
+
+	if len(errors) != len(expected) {
+		t.Fatalf("expected %d validation errors, but received %v instead", len(expected), errors)
+	}
+
+	for i, path := range expected {
+		if errors[i].Path != path || errors[i].Code != ValidationInvalidFormat {
+			t.Errorf("expected %s error for %s, but received %s for %s instead", ValidationInvalidFormat, path, errors[i].Code, errors[i].Path)
+		}
+	}
+
+	if errors[2].Value != "1.2" {
+		t.Errorf("expected offending value 1.2, but received %v instead", errors[2].Value)
+	}
+}
+
+func TestValidateReceiptNoItems(t *testing.T) {
+	api := testApi(ConsistencyPolicyFlag)
+
+	a := &Receipt{
+		Retailer:      "Target",
+		PurchaseDate:  "2022-01-01",
+		PurchaseTime:  "13:01",
+		PurchaseTotal: "35.35",
+		Items:         []ReceiptItem{},
+	}
+
+	errors := api.ValidateReceipt(a)
+
+	if len(errors) != 1 || errors[0].Path != "/items" || errors[0].Code != ValidationTooFewItems {
+		t.Errorf("expected a single %s error for /items, but received %v instead", ValidationTooFewItems, errors)
+	}
+}
+
+func TestNamespacePointer(t *testing.T) {
+	if pointer := namespacePointer("items[2].price"); pointer != "/items/2/price" {
+		t.Errorf("expected /items/2/price, but received %s instead", pointer)
+	}
+}
+
+func TestValidateReceiptConsistency(t *testing.T) {
+	newReceipt := func(total string) *Receipt {
+		return &Receipt{
+			Retailer:      "Target",
+			PurchaseDate:  "2022-01-01",
+			PurchaseTime:  "13:01",
+			PurchaseTotal: total,
+			Items: []ReceiptItem{
+				{
+					ShortDescription: "Mountain Dew 12PK",
+					Price:            "6.49",
+				},
+				{
+					ShortDescription: "Emils Cheese Pizza",
+					Price:            "12.25",
+				},
+			},
+		}
+	}
+
+	// the items add up to 18.74, and 10% tax allows a total of up to 20.61
+	cases := []struct {
+		policy  string
+		total   string
+		reject  bool
+		flagged bool
+	}{
+		{ConsistencyPolicyStrict, "18.74", false, false},
+		{ConsistencyPolicyStrict, "18.75", true, true},
+		{ConsistencyPolicyTolerance, "20.61", false, false},
+		{ConsistencyPolicyTolerance, "20.62", true, true},
+		{ConsistencyPolicyTolerance, "18.73", true, true},
+		{ConsistencyPolicyFlag, "20.61", false, false},
+		{ConsistencyPolicyFlag, "99.99", false, true},
+	}
+
+	for _, test := range cases {
+		receipt := newReceipt(test.total)
+		errors := testApi(test.policy).ValidateReceipt(receipt)
+
+		if rejected := len(errors) > 0; rejected != test.reject {
+			t.Errorf("expected %s policy rejection of total %s to be %t, but received %v", test.policy, test.total, test.reject, errors)
+		}
+
+		if test.reject && errors[0].Code != ValidationTotalMismatch {
+			t.Errorf("expected %s error, but received %s instead", ValidationTotalMismatch, errors[0].Code)
+		}
+
+		if receipt.Consistency == nil || receipt.Consistency.Flagged != test.flagged || receipt.Consistency.ItemsTotal != "18.74" {
+			t.Errorf("expected %s policy to record flagged %t for total %s, but received %v", test.policy, test.flagged, test.total, receipt.Consistency)
+		}
+	}
+}
+
+func TestValidateReceiptConsistencyLargeAmounts(t *testing.T) {
+	receipt := func(total string, prices ...string) *Receipt {
+		items := make([]ReceiptItem, len(prices))
+
+		for i, price := range prices {
+			items[i] = ReceiptItem{ShortDescription: "Gold Bar", Price: price}
+		}
+
+		return &Receipt{Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", PurchaseTotal: total, Items: items}
+	}
+
+	// the items add up to more than any total can be
+	overflow := receipt("1.00", "92233720368547757.00", "92233720368547757.00")
+
+	if errors := testApi(ConsistencyPolicyFlag).ValidateReceipt(overflow); len(errors) != 1 || errors[0].Path != "/total" {
+		t.Errorf("expected a single /total error for items that overflow, but received %v", errors)
+	}
+
+	// a discrepancy of 80% is outside the tolerance, even though multiplying either amount by 100 would overflow
+	large := receipt("90000000000000000.00", "50000000000000000.00")
+	testApi(ConsistencyPolicyFlag).ValidateReceipt(large)
+
+	if large.Consistency == nil || !large.Consistency.Flagged {
+		t.Errorf("expected a large discrepancy to be flagged, but received %v", large.Consistency)
+	}
+}
+
+func TestValidateReceiptCalendar(t *testing.T) {
+	api := testApi(ConsistencyPolicyFlag)
+	api.Config.PurchaseFutureSkew = 24 * time.Hour
+
+	receipt := func(purchaseDate string, purchaseTime string) *Receipt {
+		return &Receipt{
+			Retailer:      "Target",
+			PurchaseDate:  purchaseDate,
+			PurchaseTime:  purchaseTime,
+			PurchaseTotal: "1.25",
+			Items:         []ReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+		}
+	}
+
+	cases := []struct {
+		receipt *Receipt
+		path    string
+		code    string
+	}{
+		{receipt: receipt("2022-19-39", "13:01"), path: "/purchaseDate", code: ValidationInvalidDate},
+		{receipt: receipt("2023-02-29", "13:01"), path: "/purchaseDate", code: ValidationInvalidDate},
+		{receipt: receipt("2022-01-01", "29:59"), path: "/purchaseTime", code: ValidationInvalidTime},
+		{receipt: receipt(time.Now().AddDate(0, 0, 3).Format("2006-01-02"), "13:01"), path: "/purchaseDate", code: ValidationFuturePurchase},
+	}
+
+	for _, test := range cases {
+		errors := api.ValidateReceipt(test.receipt)
+
+		if len(errors) != 1 || errors[0].Path != test.path || errors[0].Code != test.code {
+			t.Errorf("expected %s error for %s, but received %v instead", test.code, test.path, errors)
+		}
+	}
+
+	// a leap day and a purchase within the allowed clock skew are both valid
+	for _, valid := range []*Receipt{receipt("2024-02-29", "23:59"), receipt(time.Now().Format("2006-01-02"), "23:59")} {
+		if errors := api.ValidateReceipt(valid); len(errors) > 0 {
+			t.Errorf("unexpected validation errors %v", errors)
+		}
+	}
+
+	// receipts older than the retention age are rejected once it is configured
+	api.Config.PurchaseMaxAge = 365 * 24 * time.Hour
+
+	if errors := api.ValidateReceipt(receipt("2022-01-01", "13:01")); len(errors) != 1 || errors[0].Code != ValidationExpiredPurchase {
+		t.Errorf("expected %s error, but received %v instead", ValidationExpiredPurchase, errors)
+	}
+}
+
+func TestValidateReceiptCustomer(t *testing.T) {
+	api := testApi(ConsistencyPolicyFlag)
+	api.Database = setupTestDatabase(t)
+
+	customer, _ := api.Database.InsertCustomer(&CustomerInput{Name: "Ada"})
+
+	receipt := func(customerId string) *Receipt {
+		return &Receipt{
+			Retailer:      "Target",
+			PurchaseDate:  "2022-01-01",
+			PurchaseTime:  "13:01",
+			PurchaseTotal: "1.25",
+			Items:         []ReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+			CustomerId:    customerId,
+		}
+	}
+
+	if errors := api.ValidateReceipt(receipt(customer.Id)); len(errors) > 0 {
+		t.Errorf("unexpected validation errors %v", errors)
+	}
+
+	cases := map[string]string{
+		"not-a-guid":                           ValidationInvalidFormat,
+		"3f1b6a1e-8a4c-4a7e-9a3e-2d9d6c1b7f00": ValidationUnknownCustomer,
+	}
+
+	for customerId, code := range cases {
+		if errors := api.ValidateReceipt(receipt(customerId)); len(errors) != 1 || errors[0].Path != "/customerId" || errors[0].Code != code {
+			t.Errorf("expected %s error for /customerId, but received %v instead", code, errors)
+		}
+	}
+}
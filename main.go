@@ -1,15 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/mattcolf/receipt-processor-challenge/api"
 )
 
+// Process exit codes
+const (
+	ExitOK           = 0
+	ExitServerError  = 1
+	ExitDrainTimeout = 2
+	ExitStorageError = 3
+	ExitInvalidRules = 4
+)
+
 func main() {
+	// check rule files without starting the server, eg. validate-rules promotions.yml
+	if len(os.Args) > 1 && os.Args[1] == "validate-rules" {
+		os.Exit(validateRules(os.Args[2:]))
+	}
+
 	config := api.LoadConfig()
-	api := api.SetupApi()
+	api := api.SetupApi(config)
 
 	server := &http.Server{
 		Handler:      api.Router,
@@ -18,7 +39,85 @@ func main() {
 		ReadTimeout:  config.ServerReadTimeout,
 	}
 
-	// TODO: add graceful shutdown
+	// stop accepting requests once the process is asked to terminate
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErrors := make(chan error, 1)
+
+	go func() {
+		serverErrors <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErrors:
+		log.Printf("Error while running server. %s", err)
+
+		if err := api.Close(); err != nil {
+			log.Printf("Error while flushing storage. %s", err)
+		}
+
+		os.Exit(ExitServerError)
+	case <-ctx.Done():
+		// restore the default signal behavior so that a second signal terminates the process immediately
+		stop()
+	}
+
+	os.Exit(shutdown(server, api, config.ShutdownTimeout))
+}
+
+// Validate each rule file, printing every problem that is found. Returns the exit code for the process.
+func validateRules(paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: receipt-processor-challenge-api validate-rules <file> [<file>...]")
+		return ExitInvalidRules
+	}
+
+	code := ExitOK
+
+	for _, path := range paths {
+		rules, err := api.LoadRuleFile(path)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = ExitInvalidRules
+			continue
+		}
+
+		fmt.Printf("%s: %d valid rules\n", path, len(rules))
+	}
+
+	return code
+}
+
+// Stop accepting new connections, wait for in-flight requests to complete within the deadline and flush all pending storage
+// writes. Returns the exit code for the process.
+func shutdown(server *http.Server, receiptsApi *api.ReceiptsApi, timeout time.Duration) int {
+	log.Printf("Shutting down, waiting up to %s for in-flight requests to complete.", timeout)
+
+	code := ExitOK
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Timed out while waiting for in-flight requests to complete.")
+			code = ExitDrainTimeout
+		} else {
+			log.Printf("Error while shutting down server. %s", err)
+			code = ExitServerError
+		}
+	}
+
+	// storage is flushed even when draining timed out so that every completed write is kept. Requests that are still running
+	// can't write once storage is closed, so they never complete.
+	if err := receiptsApi.Close(); err != nil {
+		log.Printf("Error while flushing storage. %s", err)
+		return ExitStorageError
+	}
+
+	log.Printf("Shutdown complete.")
 
-	log.Fatal(server.ListenAndServe())
+	return code
 }